@@ -0,0 +1,210 @@
+// Package achievements awards members for how they visit, beyond the visit
+// total and streak badges
+package achievements
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+// Rule awards achievements for a visit
+type Rule interface {
+	// Check returns the achievements earned by the last of visits. visits
+	// holds the first visit of the member and every one since Since, oldest
+	// first. Already awarded achievements are filtered out by the caller, so
+	// rules don't need to worry about awarding the same one twice.
+	Check(visits []time.Time) []types.Achievement
+	// Since returns the earliest visit needed to check a visit at last, so
+	// callers don't have to load the whole history of the member
+	Since(last time.Time) time.Time
+}
+
+// Rules are checked together
+type Rules []Rule
+
+// Default returns the built-in rules
+func Default() Rules {
+	return Rules{
+		Anniversary{},
+		HourRange{
+			Id:    "early-bird",
+			Emoji: ":hatching_chick:",
+			Msg:   "Early bird: in before 7am",
+			From:  4,
+			To:    7,
+		},
+		HourRange{
+			Id:    "night-owl",
+			Emoji: ":owl:",
+			Msg:   "Night owl: came in after 10pm",
+			From:  22,
+			To:    4,
+		},
+		WeekendWarrior{},
+		EveryWeekday{},
+	}
+}
+
+// Check checks visits against all the rules
+func (rs Rules) Check(visits []time.Time) []types.Achievement {
+	if len(visits) == 0 {
+		return nil
+	}
+
+	var result []types.Achievement
+	for _, r := range rs {
+		result = append(result, r.Check(visits)...)
+	}
+	return result
+}
+
+// Since returns the earliest visit any of the rules needs
+func (rs Rules) Since(last time.Time) time.Time {
+	since := last
+	for _, r := range rs {
+		if s := r.Since(last); s.Before(since) {
+			since = s
+		}
+	}
+	return since
+}
+
+// Anniversary is awarded on the first visit after every year since the
+// first one
+type Anniversary struct{}
+
+func (Anniversary) Check(visits []time.Time) []types.Achievement {
+	first, last := visits[0], visits[len(visits)-1]
+	years := last.Year() - first.Year()
+	if last.Month() < first.Month() || (last.Month() == first.Month() && last.Day() < first.Day()) {
+		years--
+	}
+	if years < 1 {
+		return nil
+	}
+
+	msg := "One year at the lab!"
+	if years > 1 {
+		msg = fmt.Sprintf("%d years at the lab!", years)
+	}
+	return []types.Achievement{{
+		Id:    fmt.Sprintf("anniversary-%d", years),
+		Emoji: ":birthday:",
+		Msg:   msg,
+	}}
+}
+
+// Since only needs the first visit, which is always there
+func (Anniversary) Since(last time.Time) time.Time {
+	return last
+}
+
+// HourRange is awarded for arriving between From (inclusive) and To
+// (exclusive) o'clock. The range spans midnight when From is after To.
+type HourRange struct {
+	Id    string
+	Emoji string
+	Msg   string
+	From  int
+	To    int
+}
+
+func (r HourRange) Check(visits []time.Time) []types.Achievement {
+	h := visits[len(visits)-1].Hour()
+	in := h >= r.From && h < r.To
+	if r.From > r.To {
+		in = h >= r.From || h < r.To
+	}
+	if !in {
+		return nil
+	}
+	return []types.Achievement{{Id: r.Id, Emoji: r.Emoji, Msg: r.Msg}}
+}
+
+func (HourRange) Since(last time.Time) time.Time {
+	return last
+}
+
+// WeekendWarrior is awarded for coming in on both days of a weekend
+type WeekendWarrior struct{}
+
+func (WeekendWarrior) Check(visits []time.Time) []types.Achievement {
+	last := visits[len(visits)-1]
+
+	var other time.Time
+	switch last.Weekday() {
+	case time.Saturday:
+		other = addDays(last, 1)
+	case time.Sunday:
+		other = addDays(last, -1)
+	default:
+		return nil
+	}
+	if !days(visits)[dayOf(other)] {
+		return nil
+	}
+
+	return []types.Achievement{{
+		Id:    "weekend-warrior",
+		Emoji: ":muscle:",
+		Msg:   "Weekend warrior: Saturday and Sunday at the lab",
+	}}
+}
+
+// Since needs the day before, for Sundays
+func (WeekendWarrior) Since(last time.Time) time.Time {
+	return addDays(last, -1)
+}
+
+// EveryWeekday is awarded for coming in every Monday to Friday of a month,
+// once per month
+type EveryWeekday struct{}
+
+func (EveryWeekday) Check(visits []time.Time) []types.Achievement {
+	last := visits[len(visits)-1]
+	visited := days(visits)
+
+	first := time.Date(last.Year(), last.Month(), 1, 0, 0, 0, 0, last.Location())
+	for d := first; d.Month() == last.Month(); d = addDays(d, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday && !visited[dayOf(d)] {
+			return nil
+		}
+	}
+
+	return []types.Achievement{{
+		Id:    fmt.Sprintf("every-weekday-%s", last.Format("2006-01")),
+		Emoji: ":calendar:",
+		Msg:   fmt.Sprintf("Every single weekday of %s!", last.Format("January")),
+	}}
+}
+
+// Since needs the whole month
+func (EveryWeekday) Since(last time.Time) time.Time {
+	return time.Date(last.Year(), last.Month(), 1, 0, 0, 0, 0, last.Location())
+}
+
+type day struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+func dayOf(t time.Time) day {
+	y, m, d := t.Date()
+	return day{y, m, d}
+}
+
+func days(visits []time.Time) map[day]bool {
+	result := make(map[day]bool, len(visits))
+	for _, v := range visits {
+		result[dayOf(v)] = true
+	}
+	return result
+}
+
+func addDays(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+n, 0, 0, 0, 0, t.Location())
+}
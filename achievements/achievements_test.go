@@ -0,0 +1,89 @@
+package achievements
+
+import (
+	"log"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+func ids(achievements []types.Achievement) []string {
+	var result []string
+	for _, a := range achievements {
+		result = append(result, a.Id)
+	}
+	return result
+}
+
+func TestCheck(t *testing.T) {
+	at := func(y int, m time.Month, d, h int) time.Time {
+		return time.Date(y, m, d, h, 0, 0, 0, time.UTC)
+	}
+
+	// Every weekday of January 2025, at noon
+	var january []time.Time
+	for d := at(2025, 1, 1, 12); d.Month() == time.January; d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			january = append(january, d)
+		}
+	}
+
+	// And of February, earned again for the month
+	february := january
+	for d := at(2025, 2, 1, 12); d.Month() == time.February; d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			february = append(february, d)
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		visits []time.Time
+		want   []string
+	}{
+		{"No visits", nil, nil},
+		{"Regular visit", []time.Time{at(2025, 1, 20, 18)}, nil},
+		{"Early bird", []time.Time{at(2025, 1, 20, 6)}, []string{"early-bird"}},
+		{"Night owl", []time.Time{at(2025, 1, 20, 23)}, []string{"night-owl"}},
+		{"Night owl after midnight", []time.Time{at(2025, 1, 20, 1)}, []string{"night-owl"}},
+		{"Saturday only", []time.Time{at(2025, 1, 18, 12)}, nil},
+		{"Weekend warrior", []time.Time{at(2025, 1, 18, 12), at(2025, 1, 19, 12)}, []string{"weekend-warrior"}},
+		{"Sunday and next Saturday", []time.Time{at(2025, 1, 19, 12), at(2025, 1, 25, 12)}, nil},
+		{"Almost a year", []time.Time{at(2024, 1, 21, 12), at(2025, 1, 20, 12)}, nil},
+		{"Anniversary", []time.Time{at(2024, 1, 20, 12), at(2025, 1, 20, 12)}, []string{"anniversary-1"}},
+		{"Second anniversary", []time.Time{at(2023, 1, 20, 12), at(2025, 3, 1, 12)}, []string{"anniversary-2"}},
+		{"Every weekday but the last", january[:len(january)-1], nil},
+		{"Every weekday", january, []string{"every-weekday-2025-01"}},
+		{"Every weekday of another month", february, []string{"every-weekday-2025-02"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(Default().Check(tt.visits))
+			if !slices.Equal(got, tt.want) {
+				log.Printf("want: %v", tt.want)
+				log.Printf("got : %v", got)
+				t.Errorf("achievements differ")
+			}
+		})
+	}
+}
+
+func TestSince(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		last time.Time
+		want time.Time
+	}{
+		{"Mid month", time.Date(2025, 1, 20, 18, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"Sunday the 1st", time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC), time.Date(2025, 5, 31, 0, 0, 0, 0, time.UTC)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Default().Since(tt.last); !got.Equal(tt.want) {
+				log.Printf("want: %s", tt.want)
+				log.Printf("got : %s", got)
+				t.Errorf("since differ")
+			}
+		})
+	}
+}
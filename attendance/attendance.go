@@ -0,0 +1,193 @@
+package attendance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	// Heatmap cells, and the space they take with the gap to the next one
+	cellSize = 11
+	cellStep = 13
+	// Room for the weekday and month labels
+	leftMargin = 28
+	topMargin  = 16
+
+	icsDateFormat  = "20060102"
+	icsStampFormat = "20060102T150405Z"
+	// Content lines longer than this, in octets, are folded
+	icsLineLimit = 75
+)
+
+// Colors for days with no visits, one, two, three, and four or more
+var levels = []string{"#ebedf0", "#9be9a8", "#40c463", "#30a14e", "#216e39"}
+
+// Day is a day with granted visits
+type Day struct {
+	// Midnight of the day
+	Date   time.Time
+	Visits uint
+}
+
+// Days counts the granted access records per day in loc, sorted by date
+func Days(records []types.AccessRecord, loc *time.Location) []Day {
+	counts := make(map[time.Time]uint)
+	for _, r := range records {
+		if r.AccessGranted {
+			counts[midnight(r.Timestamp.In(loc))]++
+		}
+	}
+
+	days := make([]Day, 0, len(counts))
+	for d, n := range counts {
+		days = append(days, Day{Date: d, Visits: n})
+	}
+	slices.SortFunc(days, func(a, b Day) int { return a.Date.Compare(b.Date) })
+	return days
+}
+
+// Range returns the first and last days of a year, or of the last 52 weeks
+// up to now if year is zero
+func Range(year int, now time.Time) (from, to time.Time) {
+	if year == 0 {
+		to = midnight(now)
+		return to.AddDate(0, 0, -52*7), to
+	}
+	from = time.Date(year, time.January, 1, 0, 0, 0, 0, now.Location())
+	return from, from.AddDate(1, 0, -1)
+}
+
+// Heatmap writes an SVG with a cell per day between the dates from and to,
+// both included, shaded by the number of visits. Like on GitHub, there's a
+// column per week, starting on Sunday.
+func Heatmap(w io.Writer, days []Day, from, to time.Time) error {
+	from, to = midnight(from), midnight(to)
+	visits := make(map[time.Time]uint, len(days))
+	var total uint
+	for _, d := range days {
+		if !d.Date.Before(from) && !d.Date.After(to) {
+			visits[midnight(d.Date)] = d.Visits
+			total++
+		}
+	}
+
+	start := from.AddDate(0, 0, -int(from.Weekday()))
+	weeks := daysBetween(start, to)/7 + 1
+
+	var sb strings.Builder
+	fmt.Fprintf(
+		&sb,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="9" fill="#767676">`+"\n",
+		leftMargin+weeks*cellStep,
+		topMargin+7*cellStep,
+	)
+	fmt.Fprintf(&sb, "<title>%d %s at the lab from %s to %s</title>\n", total, plural(total, "day"), from.Format(time.DateOnly), to.Format(time.DateOnly))
+
+	for i, label := range []string{"Mon", "Wed", "Fri"} {
+		fmt.Fprintf(&sb, `<text x="0" y="%d">%s</text>`+"\n", topMargin+(2*i+1)*cellStep+cellSize-2, label)
+	}
+
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		week := daysBetween(start, d) / 7
+		x, y := leftMargin+week*cellStep, topMargin+int(d.Weekday())*cellStep
+		if d.Day() == 1 || d.Equal(from) && d.Day() < 8 {
+			fmt.Fprintf(&sb, `<text x="%d" y="%d">%s</text>`+"\n", x, topMargin-5, d.Format("Jan"))
+		}
+
+		n := visits[d]
+		fmt.Fprintf(
+			&sb,
+			`<rect x="%d" y="%d" width="%d" height="%d" rx="2" fill="%s"><title>%s: %d %s</title></rect>`+"\n",
+			x, y, cellSize, cellSize, levels[min(int(n), len(levels)-1)],
+			d.Format(time.DateOnly), n, plural(n, "visit"),
+		)
+	}
+	sb.WriteString("</svg>\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Calendar writes an iCalendar feed with an all-day event for every day the
+// member came in
+func Calendar(w io.Writer, name string, days []Day, now time.Time) error {
+	sum := sha256.Sum256([]byte(name))
+	uid := hex.EncodeToString(sum[:8])
+	stamp := now.UTC().Format(icsStampFormat)
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//doorbot2//attendance//EN",
+		"CALSCALE:GREGORIAN",
+		"X-WR-CALNAME:" + escapeText(name+" at the lab"),
+	}
+	for _, d := range days {
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:%s-%s@doorbot2", d.Date.Format(icsDateFormat), uid),
+			"DTSTAMP:"+stamp,
+			"DTSTART;VALUE=DATE:"+d.Date.Format(icsDateFormat),
+			"DTEND;VALUE=DATE:"+d.Date.AddDate(0, 0, 1).Format(icsDateFormat),
+			"SUMMARY:"+escapeText(fmt.Sprintf("At the lab (%d %s)", d.Visits, plural(d.Visits, "visit"))),
+			"TRANSP:TRANSPARENT",
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteString(fold(l))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// fold splits content lines longer than the limit, as RFC 5545 requires,
+// without breaking UTF-8 sequences
+func fold(line string) string {
+	var sb strings.Builder
+	n := 0
+	for _, r := range line {
+		size := len(string(r))
+		if n+size > icsLineLimit {
+			sb.WriteString("\r\n ")
+			n = 1
+		}
+		sb.WriteRune(r)
+		n += size
+	}
+	sb.WriteString("\r\n")
+	return sb.String()
+}
+
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// daysBetween counts calendar days, which aren't always 24 hours long
+func daysBetween(from, to time.Time) int {
+	a := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+func plural(n uint, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
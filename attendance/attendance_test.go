@@ -0,0 +1,99 @@
+package attendance
+
+import (
+	"bytes"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+func TestDays(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+
+	got := Days([]types.AccessRecord{
+		// Late on the 20th in New York, but the 21st in UTC
+		{Timestamp: time.Date(2025, 1, 21, 3, 0, 0, 0, time.UTC), AccessGranted: true},
+		{Timestamp: time.Date(2025, 1, 20, 9, 0, 0, 0, loc), AccessGranted: true},
+		{Timestamp: time.Date(2025, 1, 21, 9, 0, 0, 0, loc), AccessGranted: false},
+		{Timestamp: time.Date(2025, 1, 19, 9, 0, 0, 0, loc), AccessGranted: true},
+	}, loc)
+	want := []Day{
+		{Date: time.Date(2025, 1, 19, 0, 0, 0, 0, loc), Visits: 1},
+		{Date: time.Date(2025, 1, 20, 0, 0, 0, 0, loc), Visits: 2},
+	}
+	if !slices.EqualFunc(got, want, func(a, b Day) bool { return a.Date.Equal(b.Date) && a.Visits == b.Visits }) {
+		log.Printf("want: %+v", want)
+		log.Printf("got : %+v", got)
+		t.Errorf("days differ")
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	day := time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC)
+	days := []Day{
+		{Date: day, Visits: 1},
+		{Date: day.AddDate(0, 0, 1), Visits: 7},
+		// Out of range
+		{Date: day.AddDate(0, 0, 30), Visits: 1},
+	}
+
+	var buf bytes.Buffer
+	if err := Heatmap(&buf, days, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("error rendering heatmap: %s", err)
+	}
+	got := buf.String()
+
+	// January 1st 2025 was a Wednesday, so it goes in the fourth row
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" width="93" height="107"`,
+		"<title>2 days at the lab from 2025-01-01 to 2025-01-31</title>",
+		`<rect x="28" y="55" width="11" height="11" rx="2" fill="#ebedf0"><title>2025-01-01: 0 visits</title></rect>`,
+		`fill="#9be9a8"><title>2025-01-20: 1 visit</title>`,
+		`fill="#216e39"><title>2025-01-21: 7 visits</title>`,
+		`>Jan</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not found in:\n%s", want, got)
+		}
+	}
+	if n := strings.Count(got, "<rect"); n != 31 {
+		t.Errorf("unexpected number of cells %d", n)
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	day := time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC)
+	name := "Johnny Melavo, the one and only; with a name long enough to be folded"
+
+	var buf bytes.Buffer
+	err := Calendar(&buf, name, []Day{{Date: day, Visits: 2}}, time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("error rendering calendar: %s", err)
+	}
+	got := buf.String()
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"X-WR-CALNAME:Johnny Melavo\\, the one and only\\; with a name long enough to \r\n be folded at the lab\r\n",
+		"DTSTAMP:20250201T120000Z\r\n",
+		"DTSTART;VALUE=DATE:20250120\r\nDTEND;VALUE=DATE:20250121\r\n",
+		"SUMMARY:At the lab (2 visits)\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not found in:\n%s", want, got)
+		}
+	}
+	for _, l := range strings.Split(got, "\r\n") {
+		if len(l) > icsLineLimit {
+			t.Errorf("line longer than %d octets: %q", icsLineLimit, l)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package badges
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+// Prefixes of the ids badges are awarded with, followed by the tier threshold
+const (
+	TotalPrefix  = "total-"
+	StreakPrefix = "streak-"
+)
+
+// Tier is held by members whose count is greater than Threshold, and earned
+// on the visit that takes the count to Threshold+1
+type Tier struct {
+	Threshold uint   `json:"threshold"`
+	Emoji     string `json:"emoji"`
+	Msg       string `json:"msg"`
+}
+
+// Config holds the tiers for visit totals and streaks, and when arrivals
+// after a long absence or breaking a streak are worth a mention
+type Config struct {
+	Totals  []Tier `json:"totals"`
+	Streaks []Tier `json:"streaks"`
+	// Members away for at least this many days are welcomed back. Zero
+	// disables it.
+	ComebackDays uint `json:"comeback_days"`
+	// Broken streaks of at least this many days are mentioned. Zero
+	// disables it.
+	BrokenStreak uint `json:"broken_streak"`
+}
+
+var current atomic.Pointer[Config]
+
+func init() {
+	c := Default()
+	current.Store(&c)
+}
+
+// Default returns the built-in tiers
+func Default() Config {
+	return Config{
+		Totals: []Tier{
+			{Threshold: 0, Emoji: ":fatcat:", Msg: ""},
+			{Threshold: 6, Emoji: ":fatcat-yellow:", Msg: "UNO"},
+			{Threshold: 30, Emoji: ":fatcat-green:", Msg: "TEENSY"},
+			{Threshold: 99, Emoji: ":fatcat-blue:", Msg: "RPI"},
+			{Threshold: 364, Emoji: ":fatcat-pink:", Msg: "COMMUNITY"},
+			{Threshold: 499, Emoji: ":fatcat-red:", Msg: "CORE"},
+			{Threshold: 999, Emoji: ":fatcat-black:", Msg: "PILLAR"},
+		},
+		Streaks: []Tier{
+			{Threshold: 0, Emoji: ":cat2:", Msg: ""},
+			{Threshold: 4, Emoji: ":black_cat:", Msg: "One dedicated cat!"},
+			{Threshold: 13, Emoji: ":rat:", Msg: "Lab cat to lab rat!"},
+			{Threshold: 30, Emoji: ":tiger2:", Msg: "What the ...?"},
+			{Threshold: 182, Emoji: ":leopard:", Msg: "Do you sleep here?"},
+			{Threshold: 365, Emoji: ":house_with_garden:", Msg: "You DO live here! Welcome home."},
+		},
+		ComebackDays: 30,
+		BrokenStreak: 5,
+	}
+}
+
+// Current returns the tiers in use
+func Current() Config {
+	return *current.Load()
+}
+
+// Set replaces the tiers in use
+func Set(c Config) {
+	current.Store(&c)
+}
+
+// Load reads and validates tiers from a JSON file. Tiers are returned sorted
+// by threshold.
+func Load(path string) (Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading badges file: %w", err)
+	}
+
+	// Settings missing from the file keep their defaults
+	d := Default()
+	c := Config{ComebackDays: d.ComebackDays, BrokenStreak: d.BrokenStreak}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Config{}, fmt.Errorf("error parsing badges file: %w", err)
+	}
+
+	slices.SortFunc(c.Totals, byThreshold)
+	slices.SortFunc(c.Streaks, byThreshold)
+	if err := c.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid badges in %q: %w", path, err)
+	}
+	return c, nil
+}
+
+// Reload loads the tiers at path and puts them in use. On error, the tiers in
+// use are left untouched.
+func Reload(path string) error {
+	c, err := Load(path)
+	if err != nil {
+		return err
+	}
+	Set(c)
+	return nil
+}
+
+// Validate checks both lists start with a tier for threshold 0, so every
+// member has a badge, are sorted by threshold without repeating any, and that
+// every tier has an emoji and, above threshold 0, a message
+func (c Config) Validate() error {
+	return errors.Join(
+		validateTiers("totals", c.Totals),
+		validateTiers("streaks", c.Streaks),
+	)
+}
+
+func validateTiers(kind string, tiers []Tier) error {
+	var errs []error
+	seen := make(map[uint]bool)
+	for i, t := range tiers {
+		if seen[t.Threshold] {
+			errs = append(errs, fmt.Errorf("%s: duplicated threshold %d", kind, t.Threshold))
+		} else if i > 0 && t.Threshold < tiers[i-1].Threshold {
+			errs = append(errs, fmt.Errorf("%s: threshold %d comes after %d, tiers must be sorted", kind, t.Threshold, tiers[i-1].Threshold))
+		}
+		seen[t.Threshold] = true
+
+		if strings.TrimSpace(t.Emoji) == "" {
+			errs = append(errs, fmt.Errorf("%s: threshold %d has no emoji", kind, t.Threshold))
+		}
+		if t.Threshold > 0 && strings.TrimSpace(t.Msg) == "" {
+			errs = append(errs, fmt.Errorf("%s: threshold %d has no message", kind, t.Threshold))
+		}
+	}
+
+	if !seen[0] {
+		errs = append(errs, fmt.Errorf("%s: missing tier for threshold 0, leaving a gap before the first badge", kind))
+	}
+	return errors.Join(errs...)
+}
+
+func byThreshold(a, b Tier) int {
+	return cmp.Compare(a.Threshold, b.Threshold)
+}
+
+// Total returns the tier for a visit total, and whether it was just earned
+func (c Config) Total(total uint) (Tier, bool) {
+	return find(total, c.Totals)
+}
+
+// Streak returns the tier for a streak, and whether it was just earned
+func (c Config) Streak(streak uint) (Tier, bool) {
+	return find(streak, c.Streaks)
+}
+
+// Held returns the badges held with total visits and streak, as awards. The
+// tiers held from the very first visit aren't awarded.
+func (c Config) Held(total, streak uint) []types.Achievement {
+	return append(held(TotalPrefix, total, c.Totals), held(StreakPrefix, streak, c.Streaks)...)
+}
+
+// Earned returns the badges earned by a visit bringing the stats to total and
+// streak, for when there's no record of what was awarded before
+func (c Config) Earned(total, streak uint) []types.Achievement {
+	var earned []types.Achievement
+	for _, a := range c.Held(total, streak) {
+		if a.Id == TierId(TotalPrefix, total-1) || a.Id == TierId(StreakPrefix, streak-1) {
+			earned = append(earned, a)
+		}
+	}
+	return earned
+}
+
+// TierId returns the id a tier is awarded with
+func TierId(prefix string, threshold uint) string {
+	return fmt.Sprintf("%s%d", prefix, threshold)
+}
+
+func held(prefix string, num uint, tiers []Tier) []types.Achievement {
+	var awards []types.Achievement
+	for _, t := range tiers {
+		if t.Threshold > 0 && num > t.Threshold {
+			awards = append(awards, types.Achievement{
+				Id:    TierId(prefix, t.Threshold),
+				Emoji: t.Emoji,
+				Msg:   t.Msg,
+			})
+		}
+	}
+	return awards
+}
+
+// Comeback reports whether a member away for daysAway days is welcomed back
+func (c Config) Comeback(daysAway uint) bool {
+	return c.ComebackDays > 0 && daysAway >= c.ComebackDays
+}
+
+// StreakLost reports whether breaking a streak is worth a mention
+func (c Config) StreakLost(brokenStreak uint) bool {
+	return c.BrokenStreak > 0 && brokenStreak >= c.BrokenStreak
+}
+
+// NextTotal returns the first tier above the one held with total visits, if
+// there's any left
+func (c Config) NextTotal(total uint) (Tier, bool) {
+	return next(total, c.Totals)
+}
+
+// NextStreak returns the first tier above the one held with streak, if
+// there's any left
+func (c Config) NextStreak(streak uint) (Tier, bool) {
+	return next(streak, c.Streaks)
+}
+
+func next(num uint, tiers []Tier) (Tier, bool) {
+	var tier Tier
+	found := false
+	for _, t := range tiers {
+		if num <= t.Threshold && (!found || t.Threshold < tier.Threshold) {
+			tier, found = t, true
+		}
+	}
+	return tier, found
+}
+
+func find(num uint, tiers []Tier) (Tier, bool) {
+	var tier Tier
+	found := false
+	for _, t := range tiers {
+		if num > t.Threshold && (!found || t.Threshold > tier.Threshold) {
+			tier, found = t, true
+		}
+	}
+	return tier, found && num == tier.Threshold+1
+}
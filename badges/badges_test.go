@@ -0,0 +1,222 @@
+package badges
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+func writeConfig(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "badges.json")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatalf("error writing config: %s", err)
+	}
+	return path
+}
+
+func TestFind(t *testing.T) {
+	c := Default()
+	for _, tt := range []struct {
+		name       string
+		total      uint
+		wantEmoji  string
+		wantEarned bool
+	}{
+		{name: "No visits", total: 0, wantEmoji: "", wantEarned: false},
+		{name: "First visit", total: 1, wantEmoji: ":fatcat:", wantEarned: true},
+		{name: "Below first medal", total: 6, wantEmoji: ":fatcat:", wantEarned: false},
+		{name: "First medal", total: 7, wantEmoji: ":fatcat-yellow:", wantEarned: true},
+		{name: "After first medal", total: 8, wantEmoji: ":fatcat-yellow:", wantEarned: false},
+		{name: "Last medal", total: 1500, wantEmoji: ":fatcat-black:", wantEarned: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, earned := c.Total(tt.total)
+			if got.Emoji != tt.wantEmoji || earned != tt.wantEarned {
+				t.Errorf("unexpected tier %+v (earned: %t)", got, earned)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	c := Default()
+	for _, tt := range []struct {
+		total         uint
+		wantThreshold uint
+		wantFound     bool
+	}{
+		{total: 1, wantThreshold: 6, wantFound: true},
+		{total: 6, wantThreshold: 6, wantFound: true},
+		{total: 7, wantThreshold: 30, wantFound: true},
+		{total: 999, wantThreshold: 999, wantFound: true},
+		{total: 1000, wantFound: false},
+	} {
+		got, found := c.NextTotal(tt.total)
+		if found != tt.wantFound || got.Threshold != tt.wantThreshold {
+			t.Errorf("unexpected next tier for %d: %+v (found: %t)", tt.total, got, found)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `{
+		"totals": [
+			{"threshold": 9, "emoji": ":ten:", "msg": "TEN"},
+			{"threshold": 0, "emoji": ":one:"}
+		],
+		"streaks": [
+			{"threshold": 0, "emoji": ":cat:"}
+		]
+	}`)
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("error loading config: %s", err)
+	}
+	if c.Totals[0].Threshold != 0 || c.Totals[1].Threshold != 9 {
+		t.Errorf("tiers should be sorted: %+v", c.Totals)
+	}
+	if tier, earned := c.Total(10); tier.Msg != "TEN" || !earned {
+		t.Errorf("unexpected tier %+v (earned: %t)", tier, earned)
+	}
+	if d := Default(); c.ComebackDays != d.ComebackDays || c.BrokenStreak != d.BrokenStreak {
+		t.Errorf("missing settings should keep their defaults: %+v", c)
+	}
+
+	path = writeConfig(t, `{
+		"totals": [{"threshold": 0, "emoji": ":one:"}],
+		"streaks": [{"threshold": 0, "emoji": ":cat:"}],
+		"comeback_days": 0,
+		"broken_streak": 10
+	}`)
+	c, err = Load(path)
+	if err != nil {
+		t.Fatalf("error loading config: %s", err)
+	}
+	if c.Comeback(100) || c.StreakLost(9) || !c.StreakLost(10) {
+		t.Errorf("unexpected comeback or broken streak settings: %+v", c)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	base := []Tier{{Threshold: 0, Emoji: ":cat:"}}
+	for _, tt := range []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "Empty",
+			config: Config{},
+		},
+		{
+			name:   "Missing base tier",
+			config: Config{Totals: []Tier{{Threshold: 5, Emoji: ":five:", Msg: "5"}}, Streaks: base},
+		},
+		{
+			name: "Duplicated threshold",
+			config: Config{
+				Totals:  append(base, Tier{Threshold: 5, Emoji: ":a:", Msg: "A"}, Tier{Threshold: 5, Emoji: ":b:", Msg: "B"}),
+				Streaks: base,
+			},
+		},
+		{
+			name:   "Unsorted tiers",
+			config: Config{Totals: append([]Tier{{Threshold: 5, Emoji: ":five:", Msg: "5"}}, base...), Streaks: base},
+		},
+		{
+			name:   "Missing total emoji",
+			config: Config{Totals: append(base, Tier{Threshold: 5, Msg: "5"}), Streaks: base},
+		},
+		{
+			name:   "Missing streak emoji",
+			config: Config{Totals: base, Streaks: append(base, Tier{Threshold: 5, Msg: "5"})},
+		},
+		{
+			name:   "Blank emoji",
+			config: Config{Totals: base, Streaks: append(base, Tier{Threshold: 5, Emoji: " ", Msg: "5"})},
+		},
+		{
+			name:   "Missing base tier emoji",
+			config: Config{Totals: []Tier{{Threshold: 0}}, Streaks: base},
+		},
+		{
+			name:   "Missing total message",
+			config: Config{Totals: append(base, Tier{Threshold: 5, Emoji: ":five:"}), Streaks: base},
+		},
+		{
+			name:   "Missing streak message",
+			config: Config{Totals: base, Streaks: append(base, Tier{Threshold: 5, Emoji: ":five:"})},
+		},
+		{
+			name:   "Blank message",
+			config: Config{Totals: base, Streaks: append(base, Tier{Threshold: 5, Emoji: ":five:", Msg: "\t"})},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); err == nil {
+				t.Error("expected a validation error")
+			}
+		})
+	}
+
+	if err := Default().Validate(); err != nil {
+		t.Errorf("default config should be valid: %s", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	defer Set(Default())
+
+	if err := Reload(writeConfig(t, `{"totals": [], "streaks": []}`)); err == nil {
+		t.Fatal("expected error reloading an invalid config")
+	}
+	if tier, _ := Current().Total(7); tier.Emoji != ":fatcat-yellow:" {
+		t.Errorf("current tiers shouldn't change on error")
+	}
+
+	err := Reload(writeConfig(t, `{
+		"totals": [{"threshold": 0, "emoji": ":one:"}],
+		"streaks": [{"threshold": 0, "emoji": ":cat:"}]
+	}`))
+	if err != nil {
+		t.Fatalf("error reloading: %s", err)
+	}
+	if tier, _ := Current().Total(7); tier.Emoji != ":one:" {
+		t.Errorf("current tiers should have been replaced")
+	}
+}
+
+func TestHeld(t *testing.T) {
+	c := Default()
+	for _, tt := range []struct {
+		name       string
+		total      uint
+		streak     uint
+		wantHeld   []string
+		wantEarned []string
+	}{
+		{name: "First visit", total: 1, streak: 1},
+		{name: "First medal", total: 7, streak: 1, wantHeld: []string{"total-6"}, wantEarned: []string{"total-6"}},
+		{name: "Both", total: 32, streak: 5, wantHeld: []string{"total-6", "total-30", "streak-4"}, wantEarned: []string{"streak-4"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(c.Held(tt.total, tt.streak)); !slices.Equal(got, tt.wantHeld) {
+				t.Errorf("unexpected badges held %v, want %v", got, tt.wantHeld)
+			}
+			if got := ids(c.Earned(tt.total, tt.streak)); !slices.Equal(got, tt.wantEarned) {
+				t.Errorf("unexpected badges earned %v, want %v", got, tt.wantEarned)
+			}
+		})
+	}
+}
+
+func ids(awards []types.Achievement) []string {
+	var result []string
+	for _, a := range awards {
+		result = append(result, a.Id)
+	}
+	return result
+}
@@ -3,24 +3,34 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"github.com/fatcatfablab/doorbot2/achievements"
+	"github.com/fatcatfablab/doorbot2/badges"
 	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/sender"
+	"github.com/fatcatfablab/doorbot2/types"
 	"github.com/spf13/cobra"
 )
 
 var (
-	name string
+	name         string
+	templatePath string
+	door         string
+	period       string
+	current      bool
+	all          bool
+	withAwards   bool
 
 	adminCmd = &cobra.Command{
 		Use:   "admin",
 		Short: "Admin actions on a doorbot2 database",
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			var err error
-			accessDb, err = db.New(dsn, tz)
+			accessDb, err = db.New(dsn, tz, achievements.Default())
 			if err != nil {
-				log.Fatalf("error opening database: %s", err)
+				fatal("error opening database", "err", err)
 			}
 			return err
 		},
@@ -30,16 +40,58 @@ var (
 	}
 
 	dumpCmd = &cobra.Command{
-		Use: "dump",
+		Use:   "dump",
+		Short: "Print the member's access history, or their awards with --achievements",
 		Run: func(cmd *cobra.Command, args []string) {
+			if withAwards {
+				dumpAchievements(accessDb, name)
+				return
+			}
 			dump(accessDb, name)
 		},
 	}
 
+	renderTestCmd = &cobra.Command{
+		Use:   "render-test",
+		Short: "Render the announcement template with the member's current stats",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return renderTest(accessDb, name, templatePath, door)
+		},
+	}
+
+	announceCmd = &cobra.Command{
+		Use:       "announce [public|achievements|never]",
+		Short:     "Show or set which of the member's arrivals get announced",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"public", "achievements", "never"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return announce(accessDb, name, args)
+		},
+	}
+
+	badgeCmd = &cobra.Command{
+		Use:       "badge [on|off]",
+		Short:     "Show or set whether the member's stats badge is served",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"on", "off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return shareBadge(accessDb, name, args)
+		},
+	}
+
+	leaderboardCmd = &cobra.Command{
+		Use:   "leaderboard",
+		Short: "Print the leaderboard of the last week or month",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return leaderboard(accessDb, period, current)
+		},
+	}
+
 	recomputeCmd = &cobra.Command{
-		Use: "recompute",
-		Run: func(cmd *cobra.Command, args []string) {
-			recompute(accessDb, name)
+		Use:   "recompute",
+		Short: "Recompute the member's stats and awards from their access history",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return recompute(accessDb, name, all)
 		},
 	}
 )
@@ -48,8 +100,20 @@ func init() {
 	adminCmd.PersistentFlags().StringVar(&name, "name", "", "Member name to act on")
 	adminCmd.MarkFlagRequired("name")
 
+	dumpCmd.Flags().BoolVar(&withAwards, "achievements", false, "Print the badges and achievements awarded instead")
 	adminCmd.AddCommand(dumpCmd)
+	recomputeCmd.Flags().BoolVar(&all, "all", false, "Recompute every member")
 	adminCmd.AddCommand(recomputeCmd)
+	adminCmd.AddCommand(announceCmd)
+	adminCmd.AddCommand(badgeCmd)
+
+	renderTestCmd.Flags().StringVar(&templatePath, "template", "", "Announcement template to test. Uses the built-in one if empty")
+	renderTestCmd.Flags().StringVar(&door, "door", "Front Door", "Door name to render with")
+	adminCmd.AddCommand(renderTestCmd)
+
+	leaderboardCmd.Flags().StringVar(&period, "period", sender.DigestWeekly, `"week" or "month"`)
+	leaderboardCmd.Flags().BoolVar(&current, "current", false, "Rank the period in progress instead of the last full one")
+	adminCmd.AddCommand(leaderboardCmd)
 
 	rootCmd.AddCommand(adminCmd)
 }
@@ -57,7 +121,7 @@ func init() {
 func dump(accessDb *db.DB, name string) {
 	records, err := accessDb.DumpHistory(context.Background(), name)
 	if err != nil {
-		log.Printf("error dumping history: %s", err)
+		slog.Error("error dumping history", "err", err)
 	}
 
 	for _, r := range records {
@@ -75,11 +139,154 @@ func dump(accessDb *db.DB, name string) {
 	}
 }
 
-func recompute(accessDb *db.DB, name string) {
-	s, err := accessDb.Recompute(context.Background(), name)
+func dumpAchievements(accessDb *db.DB, name string) {
+	awards, err := accessDb.Achievements(context.Background(), name)
+	if err != nil {
+		slog.Error("error dumping achievements", "err", err)
+	}
+
+	for _, a := range awards {
+		fmt.Printf(
+			"%s,%s,%s,%s,%s\n",
+			a.AwardedAt.Format("01/02/2006"),
+			a.AwardedAt.Format(time.TimeOnly),
+			a.Id,
+			a.Emoji,
+			a.Msg,
+		)
+	}
+}
+
+// recompute replays the history of the member, or every member with all, as
+// badges are awarded based on the tiers in use
+func recompute(accessDb *db.DB, name string, all bool) error {
+	if err := loadBadges(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	names := []string{name}
+	if all {
+		var err error
+		if names, err = accessDb.Members(ctx); err != nil {
+			return err
+		}
+	} else if name == "" {
+		return fmt.Errorf("--name or --all is required")
+	}
+
+	for _, n := range names {
+		s, err := accessDb.Recompute(ctx, n)
+		if err != nil {
+			slog.Error("error recomputing", "member", n, "err", err)
+			continue
+		}
+		fmt.Printf("%+v\n", s)
+	}
+	return nil
+}
+
+func renderTest(accessDb *db.DB, name, templatePath, door string) error {
+	if err := loadBadges(); err != nil {
+		return err
+	}
+
+	announcer, err := sender.NewAnnouncer(templatePath)
+	if err != nil {
+		return err
+	}
+
+	s, err := accessDb.Get(context.Background(), name)
+	if err != nil {
+		return fmt.Errorf("error getting stats: %w", err)
+	}
+	if s.Last.IsZero() {
+		s.Last = time.Now().In(accessDb.Loc())
+	}
+
+	msg, err := announcer.Render(types.Arrival{
+		Record: types.AccessRecord{Timestamp: s.Last, Name: name, AccessGranted: true},
+		Stats:  s,
+		Door:   door,
+		// As if the badges for these stats had just been earned
+		Achievements: badges.Current().Earned(s.Total, s.Streak),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(msg)
+	return nil
+}
+
+func announce(accessDb *db.DB, name string, args []string) error {
+	if name == "" {
+		return fmt.Errorf("--name is required")
+	}
+
+	ctx := context.Background()
+	if len(args) > 0 {
+		a, err := types.ParseAnnounce(args[0])
+		if err != nil {
+			return err
+		}
+		if err := accessDb.SetAnnounce(ctx, name, a); err != nil {
+			return err
+		}
+	}
+
+	a, err := accessDb.Announce(ctx, name)
+	if err != nil {
+		return err
+	}
+	fmt.Println(a)
+	return nil
+}
+
+func shareBadge(accessDb *db.DB, name string, args []string) error {
+	if name == "" {
+		return fmt.Errorf("--name is required")
+	}
+
+	ctx := context.Background()
+	if len(args) > 0 {
+		if args[0] != "on" && args[0] != "off" {
+			return fmt.Errorf(`expected "on" or "off", got %q`, args[0])
+		}
+		if err := accessDb.SetBadgeShared(ctx, name, args[0] == "on"); err != nil {
+			return err
+		}
+	}
+
+	shared, err := accessDb.BadgeShared(ctx, name)
+	if err != nil {
+		return err
+	}
+	if shared {
+		fmt.Println("on")
+	} else {
+		fmt.Println("off")
+	}
+	return nil
+}
+
+func leaderboard(accessDb *db.DB, period string, current bool) error {
+	if period != sender.DigestWeekly && period != sender.DigestMonthly {
+		return fmt.Errorf("invalid leaderboard period %q", period)
+	}
+	if err := loadBadges(); err != nil {
+		return err
+	}
+
+	from, to, err := sender.LeaderboardRange(period, time.Now().In(accessDb.Loc()), current)
+	if err != nil {
+		return err
+	}
+	l, err := sender.BuildLeaderboard(context.Background(), accessDb, period, from, to)
 	if err != nil {
-		log.Printf("error recomputing: %s", err)
+		return err
 	}
 
-	fmt.Printf("%+v\n", s)
+	fmt.Println(l.Text())
+	return nil
 }
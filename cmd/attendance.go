@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/attendance"
+	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/spf13/cobra"
+)
+
+var (
+	year int
+
+	heatmapCmd = &cobra.Command{
+		Use:   "heatmap",
+		Short: "Print an SVG heatmap of the member's visits in the last year, or in --year",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return heatmap(accessDb, name, year)
+		},
+	}
+
+	icsCmd = &cobra.Command{
+		Use:   "ics",
+		Short: "Print an iCalendar feed of the days the member came in",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ics(accessDb, name)
+		},
+	}
+)
+
+func init() {
+	heatmapCmd.Flags().IntVar(&year, "year", 0, "Year to show instead of the last 52 weeks")
+	adminCmd.AddCommand(heatmapCmd)
+	adminCmd.AddCommand(icsCmd)
+}
+
+func heatmap(accessDb *db.DB, name string, year int) error {
+	days, err := visitDays(accessDb, name)
+	if err != nil {
+		return err
+	}
+	from, to := attendance.Range(year, time.Now().In(accessDb.Loc()))
+	return attendance.Heatmap(os.Stdout, days, from, to)
+}
+
+func ics(accessDb *db.DB, name string) error {
+	days, err := visitDays(accessDb, name)
+	if err != nil {
+		return err
+	}
+	return attendance.Calendar(os.Stdout, name, days, time.Now())
+}
+
+func visitDays(accessDb *db.DB, name string) ([]attendance.Day, error) {
+	if name == "" {
+		return nil, fmt.Errorf("--name is required")
+	}
+	records, err := accessDb.DumpHistory(context.Background(), name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting history: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w %q", db.ErrUnknownMember, name)
+	}
+	return attendance.Days(records, accessDb.Loc()), nil
+}
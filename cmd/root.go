@@ -2,13 +2,19 @@ package cmd
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 
+	"github.com/fatcatfablab/doorbot2/badges"
 	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/logging"
 	"github.com/spf13/cobra"
 )
 
 var dsn string
+var badgesPath string
+var logFormat string
+var logLevel string
 var accessDb *db.DB
 
 var rootCmd = &cobra.Command{
@@ -21,6 +27,42 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&dsn, "dsn", os.Getenv("DOORBOT2_DSN"), "DSN for the mysql database")
+	rootCmd.PersistentFlags().StringVar(&badgesPath, "badges", os.Getenv("DOORBOT2_BADGES"), "JSON file with the badge tiers. Uses the built-in ones if empty")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "logFormat", envOr("DOORBOT2_LOG_FORMAT", logging.FormatText), `Log format: "text" or "json"`)
+	rootCmd.PersistentFlags().StringVar(&logLevel, "logLevel", envOr("DOORBOT2_LOG_LEVEL", "info"), `Lowest level logged: "debug", "info", "warn" or "error"`)
+	cobra.OnInitialize(setupLogging)
+}
+
+func setupLogging() {
+	if err := logging.Setup(os.Stderr, logFormat, logLevel); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// loadBadges puts the tiers in badgesPath in use, if set
+func loadBadges() error {
+	if badgesPath == "" {
+		return nil
+	}
+	if err := badges.Reload(badgesPath); err != nil {
+		return err
+	}
+	slog.Info("Badges loaded", "path", badgesPath)
+	return nil
 }
 
 func Execute() {
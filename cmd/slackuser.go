@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/sender"
+	"github.com/slack-go/slack"
+	"github.com/spf13/cobra"
+)
+
+var (
+	slackId    string
+	slackToken string
+	dryRun     bool
+	overwrite  bool
+
+	slackUserCmd = &cobra.Command{
+		Use:   "slack-user",
+		Short: "Manage the mapping between members and Slack users",
+	}
+
+	slackUserSetCmd = &cobra.Command{
+		Use:   "set",
+		Short: "Map the member to a Slack user id",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return fmt.Errorf("--name is required")
+			}
+			return accessDb.SetSlackUser(context.Background(), name, slackId)
+		},
+	}
+
+	slackUserUnsetCmd = &cobra.Command{
+		Use:   "unset",
+		Short: "Remove the Slack user of the member",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return fmt.Errorf("--name is required")
+			}
+			return accessDb.DeleteSlackUser(context.Background(), name)
+		},
+	}
+
+	slackUserListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the members mapped to a Slack user",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listSlackUsers(accessDb)
+		},
+	}
+
+	slackUserAutomatchCmd = &cobra.Command{
+		Use:   "automatch",
+		Short: "Map members to Slack users with the same real name, display name or email",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return automatchSlackUsers(accessDb, slackToken, dryRun, overwrite)
+		},
+	}
+)
+
+func init() {
+	slackUserSetCmd.Flags().StringVar(&slackId, "slackId", "", "Slack user id, like U012AB3CD")
+	slackUserSetCmd.MarkFlagRequired("slackId")
+
+	slackUserAutomatchCmd.Flags().StringVar(&slackToken, "slackToken", os.Getenv("DOORBOT2_SLACK_TOKEN"), "Slack token with the users:read and users:read.email scopes")
+	slackUserAutomatchCmd.Flags().BoolVar(&dryRun, "dryRun", false, "Print the matches without saving them")
+	slackUserAutomatchCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Replace existing mappings")
+
+	slackUserCmd.AddCommand(slackUserSetCmd)
+	slackUserCmd.AddCommand(slackUserUnsetCmd)
+	slackUserCmd.AddCommand(slackUserListCmd)
+	slackUserCmd.AddCommand(slackUserAutomatchCmd)
+	adminCmd.AddCommand(slackUserCmd)
+}
+
+func listSlackUsers(accessDb *db.DB) error {
+	users, err := accessDb.SlackUsers(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, n := range slices.Sorted(maps.Keys(users)) {
+		fmt.Printf("%s,%s\n", n, users[n])
+	}
+	return nil
+}
+
+func automatchSlackUsers(accessDb *db.DB, token string, dryRun, overwrite bool) error {
+	ctx := context.Background()
+
+	members, err := accessDb.Members(ctx)
+	if err != nil {
+		return err
+	}
+	existing, err := accessDb.SlackUsers(ctx)
+	if err != nil {
+		return err
+	}
+	users, err := slack.New(token).GetUsersContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing slack users: %w", err)
+	}
+
+	matches := sender.MatchSlackUsers(members, users)
+	for _, n := range slices.Sorted(maps.Keys(matches)) {
+		id := matches[n]
+		if prev, ok := existing[n]; ok && (prev == id || !overwrite) {
+			continue
+		}
+
+		fmt.Printf("%s,%s\n", n, id)
+		if dryRun {
+			continue
+		}
+		if err := accessDb.SetSlackUser(ctx, n, id); err != nil {
+			slog.Error("error saving match", "member", n, "err", err)
+		}
+	}
+	return nil
+}
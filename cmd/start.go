@@ -1,31 +1,69 @@
 package cmd
 
 import (
+	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/fatcatfablab/doorbot2/achievements"
 	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/events"
 	"github.com/fatcatfablab/doorbot2/httphandlers"
+	"github.com/fatcatfablab/doorbot2/metrics"
+	"github.com/fatcatfablab/doorbot2/scheduler"
 	"github.com/fatcatfablab/doorbot2/sender"
 	"github.com/fatcatfablab/doorbot2/types"
 	"github.com/spf13/cobra"
 )
 
+const (
+	// Arrivals kept for /events clients resuming after a reconnection
+	recentEvents = 100
+	// How long requests in flight get to finish when shutting down
+	shutdownTimeout = 10 * time.Second
+)
+
 var (
 	// flags
 	httpAddr     string
 	secure       bool
 	cert         string
 	key          string
-	slackToken   string
-	slackChannel string
+	slackConf    sender.SlackConfig
+	slackSecret  string
+	api          bool
+	apiTokens    bool
+	dashboard    bool
+	memberBadges bool
+	withMetrics  bool
+	liveEvents   bool
+	maxSubs      int
 	tz           string
-	silent       bool
+	announcement string
+
+	quietHours string
+	policyConf sender.PolicyConfig
+
+	webhookUrls    []string
+	webhookSecret  string
+	webhookHeaders []string
+	webhookRetries int
+
+	mqttConf sender.MQTTConfig
+
+	emailConf      sender.EmailConfig
+	digestSchedule string
+	digestPeriod   string
+
+	leaderboardSchedules = make(map[string]string)
 
 	startCmd = &cobra.Command{
 		Use:   "start",
@@ -33,9 +71,9 @@ var (
 		Run:   start,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			var err error
-			accessDb, err = db.New(dsn, tz)
+			accessDb, err = db.New(dsn, tz, achievements.Default())
 			if err != nil {
-				log.Fatalf("error opening database: %s", err)
+				fatal("error opening database", "err", err)
 			}
 			return err
 		},
@@ -45,45 +83,346 @@ var (
 	}
 )
 
+// The Slack sender, kept apart from the other senders for readiness checks
+var slackClient *sender.SlackSender
+
+// The MQTT sender, if enabled, to refresh the occupancy at midnight
+var mqttClient *sender.MQTTSender
+
 func init() {
 	pf := startCmd.PersistentFlags()
 	pf.StringVar(&httpAddr, "httpAddr", ":8443", "Address to listen on")
 	pf.BoolVar(&secure, "secure", true, "Listen using TLS")
 	pf.StringVar(&cert, "cert", "certs/cert.pem", "Path to the certificate")
 	pf.StringVar(&key, "key", "certs/key.pem", "Path to the private key")
-	pf.StringVar(&slackToken, "slackToken", os.Getenv("DOORBOT2_SLACK_TOKEN"), "Slack token")
-	pf.StringVar(&slackChannel, "slackChannel", os.Getenv("DOORBOT2_SLACK_CHANNEL"), "Slack channel")
+	pf.BoolVar(&api, "api", false, "Serve the read-only JSON API under /api/v1")
+	pf.BoolVar(&dashboard, "dashboard", false, "Serve the HTML dashboard under /dashboard")
+	pf.BoolVar(&withMetrics, "metrics", false, "Serve Prometheus metrics on /metrics")
+	pf.BoolVar(&memberBadges, "statsBadges", false, "Serve SVG stats badges of members who opted in on /badge/{name}.svg")
+	pf.BoolVar(&liveEvents, "events", false, "Stream arrivals as Server-Sent Events on /events")
+	pf.IntVar(&maxSubs, "eventsMaxSubscribers", 10, "How many clients can follow /events at a time")
+	pf.BoolVar(&apiTokens, "apiTokens", false, "Require API tokens on /api/v1, and serve the admin API under /api/v1/admin")
+	pf.StringVar(&slackConf.Token, "slackToken", os.Getenv("DOORBOT2_SLACK_TOKEN"), "Slack token")
+	pf.StringVar(&slackConf.Channel, "slackChannel", os.Getenv("DOORBOT2_SLACK_CHANNEL"), "Slack channel")
+	pf.StringVar(&slackSecret, "slackSigningSecret", os.Getenv("DOORBOT2_SLACK_SIGNING_SECRET"), "Slack app signing secret. Enables slash commands on /slack/commands")
+	pf.BoolVar(&slackConf.Blocks, "slackBlocks", false, "Post announcements using Block Kit layouts")
+	pf.BoolVar(&slackConf.Mentions, "slackMentions", false, "@-mention members mapped to a Slack user in announcements")
+	pf.BoolVar(&slackConf.Threads, "slackThreads", false, `Post arrivals as replies to a daily "Today at the lab" thread`)
+	pf.BoolVar(&slackConf.DirectMessages, "slackDMs", false, "DM members mapped to a Slack user when they earn a badge")
 	pf.StringVar(&tz, "timezone", "America/New_York", "Time zone")
-	pf.BoolVar(&silent, "silent", false, "Whether it should post to slack or not")
+	pf.BoolVar(&slackConf.Silent, "silent", false, "Whether it should post to slack or not")
+	pf.StringVar(&quietHours, "quietHours", os.Getenv("DOORBOT2_QUIET_HOURS"), `Hours without Slack announcements, like "22:00-07:00"`)
+	pf.StringVar(&policyConf.QuietMode, "quietMode", sender.QuietDefer, `What to do with arrivals during quiet hours: "defer" them to a summary when quiet hours end, or "suppress" them`)
+	pf.DurationVar(&policyConf.BatchWindow, "batchWindow", 0, "Collapse Slack announcements coming within this long of the previous one into a single message")
+	pf.StringVar(&announcement, "announcementTemplate", "", "Path to a text/template for announcements. Uses the built-in one if empty")
+	pf.StringSliceVar(&webhookUrls, "webhookUrl", envList("DOORBOT2_WEBHOOK_URLS"), "URL to POST arrivals to. Can be repeated")
+	pf.StringVar(&webhookSecret, "webhookSecret", os.Getenv("DOORBOT2_WEBHOOK_SECRET"), "Secret used to sign webhook payloads")
+	pf.StringArrayVar(&webhookHeaders, "webhookHeader", nil, `Extra header for webhook requests, as "Name: value". Can be repeated`)
+	pf.IntVar(&webhookRetries, "webhookRetries", 3, "How many times a failed webhook delivery is retried")
+
+	pf.StringVar(&mqttConf.Broker, "mqttBroker", os.Getenv("DOORBOT2_MQTT_BROKER"), "MQTT broker url, e.g. tcp://host:1883 or ssl://host:8883")
+	pf.StringVar(&mqttConf.ClientId, "mqttClientId", "doorbot2", "MQTT client id")
+	pf.StringVar(&mqttConf.Username, "mqttUsername", os.Getenv("DOORBOT2_MQTT_USERNAME"), "MQTT username")
+	pf.StringVar(&mqttConf.Password, "mqttPassword", os.Getenv("DOORBOT2_MQTT_PASSWORD"), "MQTT password")
+	pf.StringVar(&mqttConf.Prefix, "mqttTopicPrefix", "doorbot2", "Prefix for all MQTT topics")
+	pf.Uint8Var(&mqttConf.QoS, "mqttQos", 1, "MQTT QoS level (0, 1 or 2)")
+	pf.StringVar(&mqttConf.CAFile, "mqttCaFile", "", "CA certificate to verify the MQTT broker")
+	pf.StringVar(&mqttConf.CertFile, "mqttCert", "", "Client certificate for the MQTT broker")
+	pf.StringVar(&mqttConf.KeyFile, "mqttKey", "", "Client private key for the MQTT broker")
+	pf.BoolVar(&mqttConf.Insecure, "mqttInsecure", false, "Skip verification of the MQTT broker certificate")
+
+	pf.StringVar(&emailConf.Addr, "smtpAddr", os.Getenv("DOORBOT2_SMTP_ADDR"), "SMTP server as host:port")
+	pf.StringVar(&emailConf.Username, "smtpUsername", os.Getenv("DOORBOT2_SMTP_USERNAME"), "SMTP username")
+	pf.StringVar(&emailConf.Password, "smtpPassword", os.Getenv("DOORBOT2_SMTP_PASSWORD"), "SMTP password")
+	pf.StringVar(&emailConf.From, "smtpFrom", "doorbot2@localhost", "Sender address for emails")
+	pf.StringSliceVar(&emailConf.To, "digestTo", envList("DOORBOT2_DIGEST_TO"), "Address to send the email digest to. Can be repeated")
+	pf.StringVar(&digestSchedule, "digestSchedule", "0 8 * * *", "Cron schedule for the email digest")
+	pf.StringToStringVar(&leaderboardSchedules, "leaderboard", nil, `Cron schedule to post the leaderboard of the last week or month at, like week="0 9 * * 1". Can be repeated`)
+	pf.StringVar(&digestPeriod, "digestPeriod", sender.DigestDaily, `Period covered by the email digest: "day", "week" or "month"`)
 
 	rootCmd.AddCommand(startCmd)
 }
 
 func start(cmd *cobra.Command, args []string) {
 	done := make(chan os.Signal, 1)
-	signal.Notify(done, syscall.SIGINT)
+	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
 	wg := sync.WaitGroup{}
 
-	slack := sender.NewSlack(slackChannel, slackToken, silent)
-	httpServer := initHttpServer(slack)
+	if err := loadBadges(); err != nil {
+		fatal("error loading badges", "err", err)
+	}
+	syncBadges()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloadOnHup(hup)
+
+	senders, err := initSenders()
+	if err != nil {
+		fatal("error initializing senders", "err", err)
+	}
+
+	sched, err := initScheduler(senders)
+	if err != nil {
+		fatal("error initializing scheduler", "err", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sched.Run(ctx)
+	}()
+
+	httpServer := initHttpServer(senders)
 	go startHttpServer(&wg, httpServer)
 	wg.Add(1)
 
 	s := <-done
-	log.Print("Received signal ", s)
+	slog.Info("Received signal", "signal", s)
+	cancel()
 
-	if err := httpServer.Close(); err != nil {
-		log.Printf("error closing http server: %s", err)
+	// Senders are closed once no handler can post to them anymore
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error shutting down http server", "err", err)
+		if err := httpServer.Close(); err != nil {
+			slog.Error("error closing http server", "err", err)
+		}
 	}
 
 	wg.Wait()
+
+	for _, s := range senders {
+		if c, ok := s.(interface{ Close() }); ok {
+			c.Close()
+		}
+	}
+}
+
+func reloadOnHup(hup <-chan os.Signal) {
+	for range hup {
+		slog.Info("Received SIGHUP, reloading badges")
+		if err := loadBadges(); err != nil {
+			slog.Error("error reloading badges, keeping the current ones", "err", err)
+			continue
+		}
+		syncBadges()
+	}
+}
+
+// syncBadges records the badges members hold with the tiers in use, so those
+// aren't announced as just earned on their next visit
+func syncBadges() {
+	n, err := accessDb.SyncBadges(context.Background())
+	if err != nil {
+		slog.Error("error syncing badges", "err", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("Awarded badges members already held", "count", n)
+	}
 }
 
-func initHttpServer(slack types.Sender) *http.Server {
-	return &http.Server{
+func initHttpServer(s types.Sender) *http.Server {
+	opts := []httphandlers.Option{
+		httphandlers.WithSlackCommands(slackSecret),
+		httphandlers.WithReadinessCheck("slack", slackClient.CheckAuth),
+	}
+	if api {
+		opts = append(opts, httphandlers.WithApi())
+	}
+	if apiTokens {
+		opts = append(opts, httphandlers.WithApiTokens())
+	}
+	if dashboard {
+		opts = append(opts, httphandlers.WithDashboard())
+	}
+	if withMetrics {
+		opts = append(opts, httphandlers.WithMetrics())
+		metrics.NewGaugeFunc("doorbot2_occupancy", "Members who came in today.", func() (float64, error) {
+			here, err := accessDb.Here(context.Background())
+			return float64(len(here)), err
+		})
+	}
+	if memberBadges {
+		opts = append(opts, httphandlers.WithBadges())
+	}
+	var broker *events.Broker
+	if liveEvents {
+		broker = events.NewBroker(maxSubs, recentEvents)
+		opts = append(opts, httphandlers.WithEvents(broker))
+	}
+	srv := &http.Server{
 		Addr:    httpAddr,
-		Handler: httphandlers.NewMux(accessDb, slack),
+		Handler: httphandlers.NewMux(accessDb, s, opts...),
+	}
+	if broker != nil {
+		// Event streams never end on their own
+		srv.RegisterOnShutdown(broker.Close)
+	}
+	return srv
+}
+
+func initSenders() (sender.Multi, error) {
+	announcer, err := sender.NewAnnouncer(announcement)
+	if err != nil {
+		return nil, fmt.Errorf("error loading announcement template: %w", err)
+	}
+	slackClient = sender.NewSlack(slackConf, announcer, accessDb)
+	slackSender, err := initPolicy(slackClient)
+	if err != nil {
+		return nil, err
+	}
+	senders := sender.Multi{slackSender}
+
+	if len(webhookUrls) > 0 {
+		headers, err := parseHeaders(webhookHeaders)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, sender.NewWebhook(webhookUrls, webhookSecret, headers, webhookRetries))
+		slog.Info("Webhooks enabled", "urls", len(webhookUrls))
+	}
+
+	if mqttConf.Broker != "" {
+		if mqttConf.QoS > 2 {
+			return nil, fmt.Errorf("invalid mqtt qos %d", mqttConf.QoS)
+		}
+		mqttClient, err = sender.NewMQTT(mqttConf, accessDb)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, mqttClient)
+	}
+
+	return senders, nil
+}
+
+// initPolicy puts quiet hours and batching in front of s, if configured
+func initPolicy(s sender.Summarizer) (types.Sender, error) {
+	if quietHours != "" {
+		var err error
+		policyConf.QuietStart, policyConf.QuietEnd, err = sender.ParseQuietHours(quietHours)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if policyConf.QuietMode != sender.QuietDefer && policyConf.QuietMode != sender.QuietSuppress {
+		return nil, fmt.Errorf("invalid quiet mode %q", policyConf.QuietMode)
+	}
+
+	if quietHours == "" && policyConf.BatchWindow == 0 {
+		return s, nil
+	}
+	slog.Info("Announcement policy", "quiet_hours", quietHours, "quiet_mode", policyConf.QuietMode, "batch_window", policyConf.BatchWindow)
+	p := sender.NewPolicy(s, policyConf, accessDb.Loc(), accessDb)
+	if err := p.Restore(context.Background()); err != nil {
+		return nil, err
+	}
+	if withMetrics {
+		metrics.NewGaugeFunc("doorbot2_outbox_pending", "Arrivals waiting for quiet hours or a batch to end before being announced.", func() (float64, error) {
+			return float64(p.Pending()), nil
+		})
+	}
+	return p, nil
+}
+
+func initScheduler(senders sender.Multi) (*scheduler.Scheduler, error) {
+	sched := scheduler.New(accessDb.Loc())
+
+	for period, spec := range leaderboardSchedules {
+		schedule, err := scheduler.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		if period != sender.DigestWeekly && period != sender.DigestMonthly {
+			return nil, fmt.Errorf("invalid leaderboard period %q", period)
+		}
+
+		sched.Add(scheduler.Job{
+			Name:     period + "ly leaderboard",
+			Schedule: schedule,
+			Run: func(ctx context.Context, now time.Time) error {
+				from, to, err := sender.LeaderboardRange(period, now, false)
+				if err != nil {
+					return err
+				}
+				l, err := sender.BuildLeaderboard(ctx, accessDb, period, from, to)
+				if err != nil {
+					return err
+				}
+				return senders.PostLeaderboard(ctx, l)
+			},
+		})
+	}
+
+	if mqttClient != nil {
+		midnight, err := scheduler.Parse("0 0 * * *")
+		if err != nil {
+			return nil, err
+		}
+		sched.Add(scheduler.Job{
+			Name:     "mqtt occupancy",
+			Schedule: midnight,
+			Run: func(ctx context.Context, now time.Time) error {
+				return mqttClient.PublishOccupancy(ctx)
+			},
+		})
+	}
+
+	if len(emailConf.To) > 0 {
+		if emailConf.Addr == "" {
+			return nil, errors.New("smtpAddr is required to send the email digest")
+		}
+		schedule, err := scheduler.Parse(digestSchedule)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := sender.DigestRange(digestPeriod, time.Now()); err != nil {
+			return nil, err
+		}
+
+		email := sender.NewEmail(emailConf)
+		sched.Add(scheduler.Job{
+			Name:     "email digest",
+			Schedule: schedule,
+			Run: func(ctx context.Context, now time.Time) error {
+				from, to, err := sender.DigestRange(digestPeriod, now)
+				if err != nil {
+					return err
+				}
+				d, err := sender.BuildDigest(ctx, accessDb, digestPeriod, from, to)
+				if err != nil {
+					return err
+				}
+				return email.SendDigest(ctx, d)
+			},
+		})
+	}
+
+	return sched, nil
+}
+
+func parseHeaders(headers []string) (http.Header, error) {
+	h := make(http.Header)
+	for _, header := range headers {
+		k, v, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid header %q", header)
+		}
+		h.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	return h, nil
+}
+
+// envList splits a comma separated environment variable, returning nil if it
+// isn't set
+func envList(name string) []string {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
 	}
+	return strings.Split(v, ",")
 }
 
 // This function doesn't return until s is closed, or on error calling
@@ -91,9 +430,8 @@ func initHttpServer(slack types.Sender) *http.Server {
 func startHttpServer(wg *sync.WaitGroup, s *http.Server) {
 	var err error
 
-	log.Printf("Server listening on %q", httpAddr)
+	slog.Info("Server listening", "addr", httpAddr, "tls", secure)
 	if secure {
-		log.Printf("Listener will use TLS")
 		err = s.ListenAndServeTLS(cert, key)
 	} else {
 		err = s.ListenAndServe()
@@ -101,8 +439,8 @@ func startHttpServer(wg *sync.WaitGroup, s *http.Server) {
 	wg.Done()
 
 	if !errors.Is(err, http.ErrServerClosed) {
-		log.Fatal("error starting http server", err)
+		fatal("error starting http server", "err", err)
 	} else {
-		log.Print("http server closed gracefully")
+		slog.Info("http server closed gracefully")
 	}
 }
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scope string
+
+	tokenCmd = &cobra.Command{
+		Use:   "token",
+		Short: "Manage API tokens",
+	}
+
+	tokenCreateCmd = &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create an API token and print it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return createToken(accessDb, args[0], scope)
+		},
+	}
+
+	tokenRevokeCmd = &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Revoke an API token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return accessDb.RevokeToken(context.Background(), args[0])
+		},
+	}
+
+	tokenListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the API tokens",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listTokens(accessDb)
+		},
+	}
+)
+
+func init() {
+	tokenCreateCmd.Flags().StringVar(&scope, "scope", string(types.ScopeRead), `"read" for the read-only API, or "admin" for the admin API too`)
+
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	adminCmd.AddCommand(tokenCmd)
+}
+
+func createToken(accessDb *db.DB, name, scope string) error {
+	s, err := types.ParseScope(scope)
+	if err != nil {
+		return err
+	}
+
+	token, t, err := accessDb.CreateToken(context.Background(), name, s)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Token %s created with the %s scope. It won't be shown again:\n%s\n", t.Id, t.Scope, token)
+	return nil
+}
+
+func listTokens(accessDb *db.DB) error {
+	tokens, err := accessDb.Tokens(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		fmt.Printf("%s,%s,%s,%s\n", t.Id, t.Name, t.Scope, t.CreatedAt.Format(time.DateTime))
+	}
+	return nil
+}
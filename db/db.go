@@ -2,12 +2,18 @@ package db
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/metrics"
 	"github.com/fatcatfablab/doorbot2/types"
 	"github.com/go-sql-driver/mysql"
 )
@@ -29,8 +35,95 @@ CREATE TABLE IF NOT EXISTS history (
 	access_granted BOOL NOT NULL,
 	PRIMARY KEY (ts, name)
 );`
+	createSlackUsers = `
+CREATE TABLE IF NOT EXISTS slack_users (
+	name VARCHAR(255) NOT NULL,
+	slack_id VARCHAR(32) NOT NULL,
+	PRIMARY KEY (name),
+	UNIQUE KEY (slack_id)
+);`
+	createPreferences = `
+CREATE TABLE IF NOT EXISTS preferences (
+	name VARCHAR(255) NOT NULL,
+	announce VARCHAR(16) NOT NULL,
+	PRIMARY KEY (name)
+);`
+	createSlackThreads = `
+CREATE TABLE IF NOT EXISTS slack_threads (
+	day DATE NOT NULL,
+	channel VARCHAR(255) NOT NULL,
+	channel_id VARCHAR(32) NOT NULL,
+	ts VARCHAR(32) NOT NULL,
+	PRIMARY KEY (day, channel)
+);`
+	createSlackThreadAttendees = `
+CREATE TABLE IF NOT EXISTS slack_thread_attendees (
+	day DATE NOT NULL,
+	channel VARCHAR(255) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	PRIMARY KEY (day, channel, name)
+);`
+	createAchievements = `
+CREATE TABLE IF NOT EXISTS achievements (
+	name VARCHAR(255) NOT NULL,
+	id VARCHAR(64) NOT NULL,
+	emoji VARCHAR(64) NOT NULL,
+	msg VARCHAR(255) NOT NULL,
+	awarded_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (name, id)
+);`
+	createApiTokens = `
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id VARCHAR(16) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	hash CHAR(64) NOT NULL,
+	scope VARCHAR(16) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (id),
+	UNIQUE KEY (hash)
+);`
+	createSchemaVersion = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	id TINYINT UNSIGNED NOT NULL,
+	version INT UNSIGNED NOT NULL,
+	PRIMARY KEY (id)
+);`
+	createDeferredArrivals = `
+CREATE TABLE IF NOT EXISTS deferred_arrivals (
+	ts TIMESTAMP NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	arrival TEXT NOT NULL,
+	PRIMARY KEY (ts, name)
+);`
+	createBadgeOptins = `
+CREATE TABLE IF NOT EXISTS badge_optins (
+	name VARCHAR(255) NOT NULL,
+	PRIMARY KEY (name)
+);`
+)
+
+// Version of the tables created here. Bump it along with any change to them,
+// so instances running an older build notice.
+const schemaVersion = 3
+
+var (
+	ErrUnknownMember = errors.New("unknown member")
+	ErrMemberExists  = errors.New("member already exists")
+	ErrSameMember    = errors.New("same member")
 )
 
+// Tables with rows per member, keyed by name
+var memberTables = []string{
+	"history",
+	"stats",
+	"slack_users",
+	"preferences",
+	"achievements",
+	"badge_optins",
+	"slack_thread_attendees",
+	"deferred_arrivals",
+}
+
 // This is the common interface between a *sql.DB and a *sql.Tx used here,
 // so methods can seamlessly work with either
 type dbh interface {
@@ -47,16 +140,28 @@ type date struct {
 	day   int
 }
 
+// Rules award achievements for visits. See the achievements package.
+type Rules interface {
+	// Check returns the achievements earned by the last of visits, which
+	// holds the first visit of the member and every one since Since
+	Check(visits []time.Time) []types.Achievement
+	// Since returns the earliest visit needed to check a visit at last
+	Since(last time.Time) time.Time
+}
+
 type DB struct {
-	db  *sql.DB
-	loc *time.Location
+	db    *sql.DB
+	loc   *time.Location
+	rules Rules
 }
 
 func newDate(year int, month time.Month, day int) date {
 	return date{year: year, month: month, day: day}
 }
 
-func New(dsn, tz string) (*DB, error) {
+// New connects to the database in dsn. rules award achievements on every
+// visit; when nil only badges are awarded.
+func New(dsn, tz string, rules Rules) (*DB, error) {
 	loc, err := time.LoadLocation(tz)
 	if err != nil {
 		return nil, fmt.Errorf("error loading tz %q: %w", tz, err)
@@ -82,8 +187,8 @@ func New(dsn, tz string) (*DB, error) {
 		return nil, fmt.Errorf("can't ping the database: %w", err)
 	}
 
-	log.Printf("Connected to db")
-	d := &DB{db: db, loc: loc}
+	slog.Info("Connected to db")
+	d := &DB{db: db, loc: loc, rules: rules}
 	if err := d.initialize(); err != nil {
 		return nil, fmt.Errorf("error initializing db: %w", err)
 	}
@@ -94,7 +199,76 @@ func New(dsn, tz string) (*DB, error) {
 func (db *DB) initialize() error {
 	_, err1 := db.db.Exec(createStats)
 	_, err2 := db.db.Exec(createHistory)
-	return errors.Join(err1, err2)
+	_, err3 := db.db.Exec(createSlackUsers)
+	_, err4 := db.db.Exec(createPreferences)
+	_, err5 := db.db.Exec(createSlackThreads)
+	_, err6 := db.db.Exec(createAchievements)
+	_, err7 := db.db.Exec(createApiTokens)
+	_, err8 := db.db.Exec(createBadgeOptins)
+	_, err9 := db.db.Exec(createSchemaVersion)
+	_, err10 := db.db.Exec(createDeferredArrivals)
+	_, err11 := db.db.Exec(createSlackThreadAttendees)
+	if err := errors.Join(err1, err2, err3, err4, err5, err6, err7, err8, err9, err10, err11); err != nil {
+		return err
+	}
+
+	// Attendees of threads are counted from slack_thread_attendees since
+	// version 3
+	if err := db.dropColumn("slack_threads", "attendees"); err != nil {
+		return err
+	}
+
+	// Never go back, in case a newer build already upgraded the tables
+	_, err := db.db.Exec(
+		"INSERT INTO schema_version(id, version) VALUES (1, ?) "+
+			"ON DUPLICATE KEY UPDATE version = GREATEST(version, ?)",
+		schemaVersion,
+		schemaVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording schema version: %w", err)
+	}
+	return nil
+}
+
+// dropColumn drops a column left over by older builds, if it's there
+func (db *DB) dropColumn(table, column string) error {
+	var n int
+	err := db.db.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.columns "+
+			"WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?",
+		table,
+		column,
+	).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("error looking up %s.%s: %w", table, column, err)
+	}
+	if n == 0 {
+		return nil
+	}
+	if _, err := db.db.Exec("ALTER TABLE " + table + " DROP COLUMN " + column); err != nil {
+		return fmt.Errorf("error dropping %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// Ping checks the database can still be reached
+func (db *DB) Ping(ctx context.Context) error {
+	return db.db.PingContext(ctx)
+}
+
+// CheckSchema fails if the tables in the database aren't the version this
+// build expects, like when a newer one upgraded them
+func (db *DB) CheckSchema(ctx context.Context) error {
+	var v uint
+	err := db.getDbh(ctx).QueryRowContext(ctx, "SELECT version FROM schema_version WHERE id = 1").Scan(&v)
+	if err != nil {
+		return fmt.Errorf("error getting schema version: %w", err)
+	}
+	if v != schemaVersion {
+		return fmt.Errorf("schema version is %d, expected %d", v, schemaVersion)
+	}
+	return nil
 }
 
 func (db *DB) Close() error {
@@ -117,21 +291,25 @@ func (db *DB) Update(ctx context.Context, r types.Stats) (types.Stats, error) {
 	return r, err
 }
 
-func (db *DB) bumpWithTimestamp(ctx context.Context, name string, ts time.Time) (types.Stats, bool, error) {
-	lastStats, err := db.Get(ctx, name)
+// bumpWithTimestamp updates the stats of a member with a visit at ts, and
+// returns them from before and after it
+func (db *DB) bumpWithTimestamp(ctx context.Context, name string, ts time.Time) (prev, next types.Stats, err error) {
+	prev, err = db.Get(ctx, name)
 	if err != nil {
-		return types.Stats{}, false, fmt.Errorf("error retrieving record: %w", err)
+		return types.Stats{}, types.Stats{}, fmt.Errorf("error retrieving record: %w", err)
 	}
 
-	newStats, err := db.Update(ctx, bumpStats(lastStats, ts))
+	next, err = db.Update(ctx, BumpStats(prev, ts))
 	if err != nil {
-		return types.Stats{}, false, fmt.Errorf("error updating record: %w", err)
+		return types.Stats{}, types.Stats{}, fmt.Errorf("error updating record: %w", err)
 	}
 
-	return newStats, newStats.Total != lastStats.Total, nil
+	return prev, next, nil
 }
 
-func bumpStats(r types.Stats, ts time.Time) types.Stats {
+// BumpStats returns the stats resulting from a visit at ts by a member whose
+// stats were r
+func BumpStats(r types.Stats, ts time.Time) types.Stats {
 	if r.Last.IsZero() {
 		r.Total = 1
 		r.Streak = 1
@@ -160,6 +338,18 @@ func bumpStats(r types.Stats, ts time.Time) types.Stats {
 	return r
 }
 
+// DaysBetween returns the number of calendar days from from to to, in the
+// location of to
+func DaysBetween(from, to time.Time) uint {
+	from = from.In(to.Location())
+	if !from.Before(to) {
+		return 0
+	}
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return uint(end.Sub(start) / (24 * time.Hour))
+}
+
 func (db *DB) Get(ctx context.Context, name string) (types.Stats, error) {
 	row := db.getDbh(ctx).QueryRowContext(
 		ctx,
@@ -179,10 +369,24 @@ func (db *DB) Get(ctx context.Context, name string) (types.Stats, error) {
 	return r, nil
 }
 
-func (db *DB) AddRecord(ctx context.Context, r types.AccessRecord) (s types.Stats, bumped bool, err error) {
+// Visit is what storing an access record did to the member stats
+type Visit struct {
+	// Stats from before and after the record
+	Prev  types.Stats
+	Stats types.Stats
+	// Whether the record bumped the stats, being the first visit of its day
+	Bumped bool
+	// Achievements awarded for the record
+	Awards []types.Achievement
+}
+
+// AddRecord stores an access record and updates the member stats and
+// achievements, all in the same transaction
+func (db *DB) AddRecord(ctx context.Context, r types.AccessRecord) (v Visit, err error) {
+	defer observeTx("add_record", time.Now(), &err)
 	tx, err := db.db.Begin()
 	if err != nil {
-		return types.Stats{}, false, fmt.Errorf("error starting tx: %w", err)
+		return Visit{}, fmt.Errorf("error starting tx: %w", err)
 	}
 	defer func() {
 		if err != nil {
@@ -193,7 +397,6 @@ func (db *DB) AddRecord(ctx context.Context, r types.AccessRecord) (s types.Stat
 		}
 	}()
 
-	bumped = false
 	ctx = context.WithValue(ctx, dbKey{}, tx)
 	_, err = tx.ExecContext(
 		ctx,
@@ -205,26 +408,43 @@ func (db *DB) AddRecord(ctx context.Context, r types.AccessRecord) (s types.Stat
 		r.AccessGranted,
 	)
 	if err != nil {
-		return s, bumped, fmt.Errorf("error running insert: %w", err)
+		return Visit{}, fmt.Errorf("error running insert: %w", err)
 	}
 
 	if r.AccessGranted {
-		s, bumped, err = db.bumpWithTimestamp(ctx, r.Name, r.Timestamp)
+		v.Prev, v.Stats, err = db.bumpWithTimestamp(ctx, r.Name, r.Timestamp)
+		if err != nil {
+			return Visit{}, fmt.Errorf("error calling bumpWithTimestamp: %w", err)
+		}
+		v.Bumped = v.Stats.Total != v.Prev.Total
+		v.Awards, err = db.award(ctx, v.Stats, r.Timestamp)
 		if err != nil {
-			return s, bumped, fmt.Errorf("error calling bumpWithTimestamp: %w", err)
+			return Visit{}, err
 		}
 	} else {
-		s, err = db.Get(ctx, r.Name)
+		v.Stats, err = db.Get(ctx, r.Name)
 		if err != nil {
-			return s, bumped, fmt.Errorf("error calling db.Get: %w", err)
+			return Visit{}, fmt.Errorf("error calling db.Get: %w", err)
 		}
+		v.Prev = v.Stats
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		err = fmt.Errorf("error commiting tx: %w", err)
+		return Visit{}, fmt.Errorf("error commiting tx: %w", err)
 	}
-	return s, bumped, err
+	metrics.RecordsStored.Inc()
+	return v, nil
+}
+
+// observeTx records the duration of a transaction started at start, and
+// whether it was committed
+func observeTx(op string, start time.Time, err *error) {
+	result := "commit"
+	if *err != nil {
+		result = "rollback"
+	}
+	metrics.TxDuration.Observe(time.Since(start).Seconds(), op, result)
 }
 
 func (db *DB) getDbh(ctx context.Context) dbh {
@@ -262,22 +482,22 @@ func (db *DB) DumpHistory(ctx context.Context, name string) ([]types.AccessRecor
 	return result, nil
 }
 
+// Recompute rebuilds the stats and awards of a member from their history
 func (db *DB) Recompute(ctx context.Context, name string) (stats types.Stats, err error) {
-	tx, err := db.db.Begin()
-	if err != nil {
-		return types.Stats{}, fmt.Errorf("error starting tx: %w", err)
-	}
-	defer func() {
-		if err != nil {
-			rerr := tx.Rollback()
-			if rerr != nil {
-				err = errors.Join(err, rerr)
-			}
+	err = db.inTx(ctx, "recompute", func(ctx context.Context) error {
+		if ok, err := db.memberExists(ctx, name); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%w %q", ErrUnknownMember, name)
 		}
-	}()
+		stats, err = db.recompute(ctx, name)
+		return err
+	})
+	return stats, err
+}
 
-	ctx = context.WithValue(ctx, dbKey{}, tx)
-	_, err = tx.ExecContext(
+func (db *DB) recompute(ctx context.Context, name string) (stats types.Stats, err error) {
+	_, err = db.getDbh(ctx).ExecContext(
 		ctx,
 		"DELETE FROM stats WHERE name = ?",
 		name,
@@ -285,22 +505,965 @@ func (db *DB) Recompute(ctx context.Context, name string) (stats types.Stats, er
 	if err != nil {
 		return types.Stats{}, fmt.Errorf("can't delete stats: %w", err)
 	}
+	_, err = db.getDbh(ctx).ExecContext(
+		ctx,
+		"DELETE FROM achievements WHERE name = ?",
+		name,
+	)
+	if err != nil {
+		return types.Stats{}, fmt.Errorf("can't delete achievements: %w", err)
+	}
 
+	// Replaying the visits awards everything again, dated when it was earned.
+	// Visits are loaded once and the stats kept in memory, so each visit only
+	// costs the awards it grants.
 	records, err := db.DumpHistory(ctx, name)
+	if err != nil {
+		return types.Stats{}, err
+	}
+	var (
+		visits []time.Time
+		// Index of the first visit the rules need for the current one
+		since   int
+		seen    = make(map[string]bool)
+		current = types.Stats{Name: name}
+	)
 	for _, r := range records {
 		if !r.AccessGranted {
 			continue
 		}
-		stats, _, err = db.bumpWithTimestamp(ctx, r.Name, r.Timestamp)
-		if err != nil {
+		current = BumpStats(current, r.Timestamp)
+		visits = append(visits, r.Timestamp.In(db.loc))
+		var window []time.Time
+		if db.rules != nil {
+			from := db.rules.Since(visits[len(visits)-1])
+			for since < len(visits)-1 && visits[since].Before(from) {
+				since++
+			}
+			window = visits[since:]
+			if since > 0 {
+				window = append([]time.Time{visits[0]}, window...)
+			}
+		}
+		if _, err = db.grantNew(ctx, current, r.Timestamp, window, seen); err != nil {
 			return types.Stats{}, err
 		}
 	}
 
-	err = tx.Commit()
+	if len(visits) > 0 {
+		if stats, err = db.Update(ctx, current); err != nil {
+			return types.Stats{}, fmt.Errorf("error updating record: %w", err)
+		}
+	}
+	return stats, nil
+}
+
+// inTx runs f with a transaction in its context, which is committed if f
+// succeeds and rolled back otherwise. op names it in the metrics.
+func (db *DB) inTx(ctx context.Context, op string, f func(ctx context.Context) error) (err error) {
+	defer observeTx(op, time.Now(), &err)
+	tx, err := db.db.Begin()
 	if err != nil {
-		return types.Stats{}, fmt.Errorf("error commiting: %w", err)
+		return fmt.Errorf("error starting tx: %w", err)
 	}
 
-	return stats, nil
+	if err := f(context.WithValue(ctx, dbKey{}, tx)); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error commiting: %w", err)
+	}
+	return nil
+}
+
+// memberExists reports whether there are access records for a member
+func (db *DB) memberExists(ctx context.Context, name string) (bool, error) {
+	var n int
+	row := db.getDbh(ctx).QueryRowContext(ctx, "SELECT COUNT(*) FROM history WHERE name = ?", name)
+	if err := row.Scan(&n); err != nil {
+		return false, fmt.Errorf("error looking up %q: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+// memberInUse reports whether any of memberTables has rows for a name
+func (db *DB) memberInUse(ctx context.Context, name string) (bool, error) {
+	for _, table := range memberTables {
+		var n int
+		row := db.getDbh(ctx).QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE name = ?", name)
+		if err := row.Scan(&n); err != nil {
+			return false, fmt.Errorf("error looking up %q in %s: %w", name, table, err)
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RenameMember moves everything recorded for a member to a new name, which
+// can't be in use
+func (db *DB) RenameMember(ctx context.Context, from, to string) error {
+	if from == to {
+		return fmt.Errorf("%w: can't rename %q to itself", ErrSameMember, from)
+	}
+
+	return db.inTx(ctx, "rename_member", func(ctx context.Context) error {
+		if ok, err := db.memberExists(ctx, from); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%w %q", ErrUnknownMember, from)
+		}
+		if ok, err := db.memberInUse(ctx, to); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("%w %q, merge instead", ErrMemberExists, to)
+		}
+
+		for _, table := range memberTables {
+			_, err := db.getDbh(ctx).ExecContext(ctx, "UPDATE "+table+" SET name = ? WHERE name = ?", to, from)
+			if err != nil {
+				return fmt.Errorf("error renaming %q in %s: %w", from, table, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MergeMember moves the history of a member into another one, like when a
+// member got a second badge under a different name, and recomputes the stats
+// of the latter. The Slack user and preferences of into are kept if set, and
+// the badge stays shared if either shared it.
+func (db *DB) MergeMember(ctx context.Context, from, into string) (stats types.Stats, err error) {
+	if from == into {
+		return types.Stats{}, fmt.Errorf("%w: can't merge %q into itself", ErrSameMember, from)
+	}
+
+	err = db.inTx(ctx, "merge_member", func(ctx context.Context) error {
+		records, err := db.DumpHistory(ctx, from)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return fmt.Errorf("%w %q", ErrUnknownMember, from)
+		}
+
+		for _, r := range records {
+			// Records at the same time are merged as granted if either was
+			_, err := db.getDbh(ctx).ExecContext(
+				ctx,
+				"INSERT INTO history(ts, name, access_granted) VALUES (?, ?, ?) "+
+					"ON DUPLICATE KEY UPDATE access_granted = access_granted OR ?",
+				r.Timestamp,
+				into,
+				r.AccessGranted,
+				r.AccessGranted,
+			)
+			if err != nil {
+				return fmt.Errorf("error moving history: %w", err)
+			}
+		}
+
+		// Rows of both members for the same thread or arrival are kept once
+		for _, table := range []string{"slack_thread_attendees", "deferred_arrivals"} {
+			_, err := db.getDbh(ctx).ExecContext(ctx, "UPDATE IGNORE "+table+" SET name = ? WHERE name = ?", into, from)
+			if err != nil {
+				return fmt.Errorf("error moving %s: %w", table, err)
+			}
+		}
+
+		for _, table := range []string{"slack_users", "preferences", "badge_optins"} {
+			var n int
+			row := db.getDbh(ctx).QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE name = ?", into)
+			if err := row.Scan(&n); err != nil {
+				return fmt.Errorf("error querying %s: %w", table, err)
+			}
+			if n == 0 {
+				_, err := db.getDbh(ctx).ExecContext(ctx, "UPDATE "+table+" SET name = ? WHERE name = ?", into, from)
+				if err != nil {
+					return fmt.Errorf("error moving %s: %w", table, err)
+				}
+			}
+		}
+
+		if err := db.deleteMember(ctx, from); err != nil {
+			return err
+		}
+		stats, err = db.recompute(ctx, into)
+		return err
+	})
+	return stats, err
+}
+
+// DeleteMember removes everything recorded for a member
+func (db *DB) DeleteMember(ctx context.Context, name string) error {
+	return db.inTx(ctx, "delete_member", func(ctx context.Context) error {
+		if ok, err := db.memberExists(ctx, name); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%w %q", ErrUnknownMember, name)
+		}
+		return db.deleteMember(ctx, name)
+	})
+}
+
+func (db *DB) deleteMember(ctx context.Context, name string) error {
+	for _, table := range memberTables {
+		_, err := db.getDbh(ctx).ExecContext(ctx, "DELETE FROM "+table+" WHERE name = ?", name)
+		if err != nil {
+			return fmt.Errorf("error deleting %q from %s: %w", name, table, err)
+		}
+	}
+	return nil
+}
+
+// History returns the access records of all members between from (inclusive)
+// and to (exclusive), sorted by timestamp
+func (db *DB) History(ctx context.Context, from, to time.Time) ([]types.AccessRecord, error) {
+	rows, err := db.getDbh(ctx).QueryContext(
+		ctx,
+		"SELECT ts, name, access_granted FROM history WHERE ts >= ? AND ts < ? ORDER BY ts ASC",
+		from,
+		to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying history: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]types.AccessRecord, 0)
+	for rows.Next() {
+		var r types.AccessRecord
+		if err := rows.Scan(&r.Timestamp, &r.Name, &r.AccessGranted); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// MemberHistory returns up to limit access records of a member, sorted by
+// timestamp, starting at from (inclusive) and before to (exclusive). Zero
+// times leave that end open.
+func (db *DB) MemberHistory(ctx context.Context, name string, from, to time.Time, limit int) ([]types.AccessRecord, error) {
+	query := "SELECT ts, name, access_granted FROM history WHERE name = ?"
+	args := []any{name}
+	if !from.IsZero() {
+		query += " AND ts >= ?"
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		query += " AND ts < ?"
+		args = append(args, to)
+	}
+	query += " ORDER BY ts ASC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.getDbh(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying history: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]types.AccessRecord, 0)
+	for rows.Next() {
+		var r types.AccessRecord
+		if err := rows.Scan(&r.Timestamp, &r.Name, &r.AccessGranted); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		r.Timestamp = r.Timestamp.In(db.loc)
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// Arrivals returns the first granted access record of every member that came
+// in at or after since, sorted by arrival time
+func (db *DB) Arrivals(ctx context.Context, since time.Time) ([]types.AccessRecord, error) {
+	rows, err := db.getDbh(ctx).QueryContext(
+		ctx,
+		"SELECT name, MIN(ts) AS first FROM history "+
+			"WHERE access_granted AND ts >= ? GROUP BY name ORDER BY first ASC",
+		since,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying arrivals: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]types.AccessRecord, 0)
+	for rows.Next() {
+		r := types.AccessRecord{AccessGranted: true}
+		if err := rows.Scan(&r.Name, &r.Timestamp); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// Here returns the members that have come in today. Exits aren't tracked, so
+// this is the closest thing to the current occupancy of the lab.
+func (db *DB) Here(ctx context.Context) ([]types.AccessRecord, error) {
+	return db.Arrivals(ctx, db.StartOfDay(time.Now()))
+}
+
+// StartOfDay returns midnight of the day t falls in, in the db location
+func (db *DB) StartOfDay(t time.Time) time.Time {
+	y, m, d := t.In(db.loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, db.loc)
+}
+
+// Top returns the stats of the limit members with the most visits
+func (db *DB) Top(ctx context.Context, limit int) ([]types.Stats, error) {
+	rows, err := db.getDbh(ctx).QueryContext(
+		ctx,
+		"SELECT name, total, streak, last FROM stats ORDER BY total DESC, name ASC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying top members: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]types.Stats, 0)
+	for rows.Next() {
+		var s types.Stats
+		if err := rows.Scan(&s.Name, &s.Total, &s.Streak, &s.Last); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
+
+// Members returns the names of all members with stats, sorted
+func (db *DB) Members(ctx context.Context) ([]string, error) {
+	rows, err := db.getDbh(ctx).QueryContext(ctx, "SELECT name FROM stats ORDER BY name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("error querying members: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		result = append(result, name)
+	}
+
+	return result, rows.Err()
+}
+
+// StatsPage returns up to limit member stats sorted by name, starting at the
+// member named from
+func (db *DB) StatsPage(ctx context.Context, from string, limit int) ([]types.Stats, error) {
+	rows, err := db.getDbh(ctx).QueryContext(
+		ctx,
+		"SELECT name, total, streak, last FROM stats WHERE name >= ? ORDER BY name ASC LIMIT ?",
+		from,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying stats: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]types.Stats, 0)
+	for rows.Next() {
+		var s types.Stats
+		if err := rows.Scan(&s.Name, &s.Total, &s.Streak, &s.Last); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		s.Last = s.Last.In(db.loc)
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
+
+// SetSlackUser maps a member to a Slack user id, replacing any previous
+// mapping for the member
+func (db *DB) SetSlackUser(ctx context.Context, name, slackId string) error {
+	other, err := db.MemberBySlackUser(ctx, slackId)
+	if err != nil {
+		return err
+	}
+	if other != "" && other != name {
+		return fmt.Errorf("slack user %q is already mapped to %q", slackId, other)
+	}
+
+	_, err = db.getDbh(ctx).ExecContext(
+		ctx,
+		"INSERT INTO slack_users(name, slack_id) VALUES (?, ?) "+
+			"ON DUPLICATE KEY UPDATE slack_id=?",
+		name,
+		slackId,
+		slackId,
+	)
+	if err != nil {
+		return fmt.Errorf("error mapping %q to %q: %w", name, slackId, err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteSlackUser(ctx context.Context, name string) error {
+	_, err := db.getDbh(ctx).ExecContext(ctx, "DELETE FROM slack_users WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("error deleting slack user for %q: %w", name, err)
+	}
+	return nil
+}
+
+// SlackUser returns the Slack user id of a member, or an empty string if the
+// member isn't mapped
+func (db *DB) SlackUser(ctx context.Context, name string) (string, error) {
+	var id string
+	err := db.getDbh(ctx).QueryRowContext(
+		ctx,
+		"SELECT slack_id FROM slack_users WHERE name = ?",
+		name,
+	).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("error getting slack user for %q: %w", name, err)
+	}
+	return id, nil
+}
+
+// MemberBySlackUser returns the member mapped to a Slack user id, or an empty
+// string if there's none
+func (db *DB) MemberBySlackUser(ctx context.Context, slackId string) (string, error) {
+	var name string
+	err := db.getDbh(ctx).QueryRowContext(
+		ctx,
+		"SELECT name FROM slack_users WHERE slack_id = ?",
+		slackId,
+	).Scan(&name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("error getting member for %q: %w", slackId, err)
+	}
+	return name, nil
+}
+
+// SlackUsers returns all the member to Slack user id mappings
+func (db *DB) SlackUsers(ctx context.Context) (map[string]string, error) {
+	rows, err := db.getDbh(ctx).QueryContext(ctx, "SELECT name, slack_id FROM slack_users")
+	if err != nil {
+		return nil, fmt.Errorf("error querying slack users: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]string)
+	for rows.Next() {
+		var name, id string
+		if err := rows.Scan(&name, &id); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		result[name] = id
+	}
+
+	return result, rows.Err()
+}
+
+// Announce returns the announce preference of a member, which is
+// types.AnnouncePublic unless set otherwise
+func (db *DB) Announce(ctx context.Context, name string) (types.Announce, error) {
+	var a string
+	err := db.getDbh(ctx).QueryRowContext(
+		ctx,
+		"SELECT announce FROM preferences WHERE name = ?",
+		name,
+	).Scan(&a)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.AnnouncePublic, nil
+	} else if err != nil {
+		return "", fmt.Errorf("error getting preferences for %q: %w", name, err)
+	}
+	return types.ParseAnnounce(a)
+}
+
+// OptedOut returns the members who never want their arrivals announced, so
+// they can be left out of anything listing members by name
+func (db *DB) OptedOut(ctx context.Context) (map[string]bool, error) {
+	rows, err := db.getDbh(ctx).QueryContext(
+		ctx,
+		"SELECT name FROM preferences WHERE announce = ?",
+		types.AnnounceNever,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying preferences: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		result[name] = true
+	}
+
+	return result, rows.Err()
+}
+
+func (db *DB) SetAnnounce(ctx context.Context, name string, a types.Announce) error {
+	_, err := db.getDbh(ctx).ExecContext(
+		ctx,
+		"INSERT INTO preferences(name, announce) VALUES (?, ?) "+
+			"ON DUPLICATE KEY UPDATE announce=?",
+		name,
+		a,
+		a,
+	)
+	if err != nil {
+		return fmt.Errorf("error setting preferences for %q: %w", name, err)
+	}
+	return nil
+}
+
+// BadgeShared reports whether a member opted in to serving their stats badge
+func (db *DB) BadgeShared(ctx context.Context, name string) (bool, error) {
+	var n int
+	row := db.getDbh(ctx).QueryRowContext(ctx, "SELECT COUNT(*) FROM badge_optins WHERE name = ?", name)
+	if err := row.Scan(&n); err != nil {
+		return false, fmt.Errorf("error getting badge opt-in for %q: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+func (db *DB) SetBadgeShared(ctx context.Context, name string, shared bool) error {
+	query := "DELETE FROM badge_optins WHERE name = ?"
+	if shared {
+		query = "INSERT IGNORE INTO badge_optins(name) VALUES (?)"
+	}
+	if _, err := db.getDbh(ctx).ExecContext(ctx, query, name); err != nil {
+		return fmt.Errorf("error setting badge opt-in for %q: %w", name, err)
+	}
+	return nil
+}
+
+// DeferArrival stores an arrival held back during quiet hours
+func (db *DB) DeferArrival(ctx context.Context, a types.Arrival) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("error encoding arrival: %w", err)
+	}
+	_, err = db.getDbh(ctx).ExecContext(
+		ctx,
+		"INSERT INTO deferred_arrivals(ts, name, arrival) VALUES (?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE arrival=?",
+		a.Record.Timestamp,
+		a.Stats.Name,
+		b,
+		b,
+	)
+	if err != nil {
+		return fmt.Errorf("error deferring arrival of %q: %w", a.Stats.Name, err)
+	}
+	return nil
+}
+
+// DeferredArrivals returns the arrivals held back, oldest first
+func (db *DB) DeferredArrivals(ctx context.Context) ([]types.Arrival, error) {
+	rows, err := db.getDbh(ctx).QueryContext(ctx, "SELECT name, arrival FROM deferred_arrivals ORDER BY ts ASC")
+	if err != nil {
+		return nil, fmt.Errorf("error querying deferred arrivals: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]types.Arrival, 0)
+	for rows.Next() {
+		var (
+			name string
+			b    []byte
+		)
+		if err := rows.Scan(&name, &b); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		var a types.Arrival
+		if err := json.Unmarshal(b, &a); err != nil {
+			return nil, fmt.Errorf("error decoding deferred arrival: %w", err)
+		}
+		// Members may have been renamed or merged since
+		a.Record.Name, a.Stats.Name = name, name
+		result = append(result, a)
+	}
+
+	return result, rows.Err()
+}
+
+func (db *DB) ClearDeferredArrivals(ctx context.Context) error {
+	if _, err := db.getDbh(ctx).ExecContext(ctx, "DELETE FROM deferred_arrivals"); err != nil {
+		return fmt.Errorf("error clearing deferred arrivals: %w", err)
+	}
+	return nil
+}
+
+// SlackThread returns the thread of a day, formatted as 2006-01-02, in a
+// channel. The returned bool is false if there's none.
+func (db *DB) SlackThread(ctx context.Context, day, channel string) (types.SlackThread, bool, error) {
+	var t types.SlackThread
+	err := db.getDbh(ctx).QueryRowContext(
+		ctx,
+		"SELECT channel_id, ts FROM slack_threads WHERE day = ? AND channel = ?",
+		day,
+		channel,
+	).Scan(&t.ChannelId, &t.Ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, false, nil
+	} else if err != nil {
+		return t, false, fmt.Errorf("error getting slack thread for %s: %w", day, err)
+	}
+	return t, true, nil
+}
+
+func (db *DB) SetSlackThread(ctx context.Context, day, channel string, t types.SlackThread) error {
+	_, err := db.getDbh(ctx).ExecContext(
+		ctx,
+		"INSERT INTO slack_threads(day, channel, channel_id, ts) VALUES (?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE channel_id=?, ts=?",
+		day,
+		channel,
+		t.ChannelId,
+		t.Ts,
+		t.ChannelId,
+		t.Ts,
+	)
+	if err != nil {
+		return fmt.Errorf("error storing slack thread for %s: %w", day, err)
+	}
+	return nil
+}
+
+// AddSlackThreadAttendees records the members announced in the thread of a
+// day, and returns how many different ones there are so far
+func (db *DB) AddSlackThreadAttendees(ctx context.Context, day, channel string, names []string) (uint, error) {
+	var count uint
+	err := db.inTx(ctx, "add_thread_attendees", func(ctx context.Context) error {
+		for _, name := range names {
+			_, err := db.getDbh(ctx).ExecContext(
+				ctx,
+				"INSERT IGNORE INTO slack_thread_attendees(day, channel, name) VALUES (?, ?, ?)",
+				day,
+				channel,
+				name,
+			)
+			if err != nil {
+				return fmt.Errorf("error adding attendee %q to slack thread for %s: %w", name, day, err)
+			}
+		}
+		return db.getDbh(ctx).QueryRowContext(
+			ctx,
+			"SELECT COUNT(*) FROM slack_thread_attendees WHERE day = ? AND channel = ?",
+			day,
+			channel,
+		).Scan(&count)
+	})
+	return count, err
+}
+
+// award stores the badges and achievements earned with stats s by a visit at
+// ts which weren't awarded before, and returns them
+func (db *DB) award(ctx context.Context, s types.Stats, ts time.Time) ([]types.Achievement, error) {
+	var visits []time.Time
+	if db.rules != nil {
+		var err error
+		visits, err = db.ruleVisits(ctx, s.Name, ts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	awarded, err := db.Achievements(ctx, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(awarded))
+	for _, a := range awarded {
+		seen[a.Id] = true
+	}
+
+	return db.grantNew(ctx, s, ts, visits, seen)
+}
+
+// grantNew stores the badges held with stats s and the achievements earned by
+// the last of visits, at ts, skipping the ones in seen. Granted ones are added
+// to seen and returned.
+func (db *DB) grantNew(ctx context.Context, s types.Stats, ts time.Time, visits []time.Time, seen map[string]bool) ([]types.Achievement, error) {
+	earned := badges.Current().Held(s.Total, s.Streak)
+	if db.rules != nil {
+		earned = append(earned, db.rules.Check(visits)...)
+	}
+
+	var result []types.Achievement
+	for _, a := range earned {
+		if seen[a.Id] {
+			continue
+		}
+		seen[a.Id] = true
+
+		a.AwardedAt = ts
+		if err := db.grant(ctx, s.Name, a); err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "Achievement awarded", "achievement", a.Id, "member", s.Name)
+		result = append(result, a)
+	}
+
+	return result, nil
+}
+
+// ruleVisits returns the visits the rules need to check a visit at ts: the
+// first visit of the member and every one since rules.Since(ts), oldest first
+func (db *DB) ruleVisits(ctx context.Context, name string, ts time.Time) ([]time.Time, error) {
+	rows, err := db.getDbh(ctx).QueryContext(
+		ctx,
+		"SELECT ts FROM history WHERE name = ? AND access_granted AND ts <= ? AND "+
+			"(ts >= ? OR ts = (SELECT MIN(ts) FROM history WHERE name = ? AND access_granted)) "+
+			"ORDER BY ts ASC",
+		name,
+		ts,
+		db.rules.Since(ts.In(db.loc)),
+		name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying visits: %w", err)
+	}
+	defer rows.Close()
+
+	var visits []time.Time
+	for rows.Next() {
+		var v time.Time
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		visits = append(visits, v.In(db.loc))
+	}
+	return visits, rows.Err()
+}
+
+func (db *DB) grant(ctx context.Context, name string, a types.Achievement) error {
+	_, err := db.getDbh(ctx).ExecContext(
+		ctx,
+		"INSERT INTO achievements(name, id, emoji, msg, awarded_at) VALUES (?, ?, ?, ?, ?)",
+		name,
+		a.Id,
+		a.Emoji,
+		a.Msg,
+		a.AwardedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error awarding %q to %q: %w", a.Id, name, err)
+	}
+	return nil
+}
+
+// SyncBadges silently awards the badges members already hold but have no
+// record of, like those earned before awards were stored or below a newly
+// added tier, dated on their last visit. Recompute dates them accurately.
+// It returns how many badges were awarded.
+func (db *DB) SyncBadges(ctx context.Context) (int, error) {
+	rows, err := db.getDbh(ctx).QueryContext(ctx, "SELECT name, total, streak, last FROM stats")
+	if err != nil {
+		return 0, fmt.Errorf("error querying stats: %w", err)
+	}
+	var stats []types.Stats
+	for rows.Next() {
+		var s types.Stats
+		if err := rows.Scan(&s.Name, &s.Total, &s.Streak, &s.Last); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning row: %w", err)
+		}
+		stats = append(stats, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	conf := badges.Current()
+	synced := 0
+	for _, s := range stats {
+		awarded, err := db.Achievements(ctx, s.Name)
+		if err != nil {
+			return synced, err
+		}
+		seen := make(map[string]bool, len(awarded))
+		for _, a := range awarded {
+			seen[a.Id] = true
+		}
+
+		for _, a := range conf.Held(s.Total, s.Streak) {
+			if seen[a.Id] {
+				continue
+			}
+			a.AwardedAt = s.Last
+			if err := db.grant(ctx, s.Name, a); err != nil {
+				return synced, err
+			}
+			synced++
+		}
+	}
+
+	return synced, nil
+}
+
+// Achievements returns the achievements awarded to a member, oldest first
+func (db *DB) Achievements(ctx context.Context, name string) ([]types.Achievement, error) {
+	rows, err := db.getDbh(ctx).QueryContext(
+		ctx,
+		"SELECT id, emoji, msg, awarded_at FROM achievements WHERE name = ? ORDER BY awarded_at ASC, id ASC",
+		name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying achievements: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]types.Achievement, 0)
+	for rows.Next() {
+		var a types.Achievement
+		if err := rows.Scan(&a.Id, &a.Emoji, &a.Msg, &a.AwardedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		a.AwardedAt = a.AwardedAt.In(db.loc)
+		result = append(result, a)
+	}
+
+	return result, rows.Err()
+}
+
+// AwardedBetween returns the achievements awarded to any member from
+// (inclusive) to to (exclusive), oldest first
+func (db *DB) AwardedBetween(ctx context.Context, from, to time.Time) ([]types.Award, error) {
+	rows, err := db.getDbh(ctx).QueryContext(
+		ctx,
+		"SELECT name, id, emoji, msg, awarded_at FROM achievements WHERE awarded_at >= ? AND awarded_at < ? "+
+			"ORDER BY awarded_at ASC, name ASC, id ASC",
+		from,
+		to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying achievements: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]types.Award, 0)
+	for rows.Next() {
+		var a types.Award
+		if err := rows.Scan(&a.Name, &a.Id, &a.Emoji, &a.Msg, &a.AwardedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		a.AwardedAt = a.AwardedAt.In(db.loc)
+		result = append(result, a)
+	}
+
+	return result, rows.Err()
+}
+
+// CreateToken stores a new API token and returns it. Only its hash is stored,
+// so this is the only chance to get the token itself.
+func (db *DB) CreateToken(ctx context.Context, name string, scope types.Scope) (string, types.Token, error) {
+	id, err := randomHex(4)
+	if err != nil {
+		return "", types.Token{}, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", types.Token{}, err
+	}
+	token := id + "." + secret
+
+	t := types.Token{Id: id, Name: name, Scope: scope, CreatedAt: time.Now().In(db.loc)}
+	_, err = db.getDbh(ctx).ExecContext(
+		ctx,
+		"INSERT INTO api_tokens(id, name, hash, scope, created_at) VALUES (?, ?, ?, ?, ?)",
+		t.Id,
+		t.Name,
+		hashToken(token),
+		t.Scope,
+		t.CreatedAt,
+	)
+	if err != nil {
+		return "", types.Token{}, fmt.Errorf("error storing token: %w", err)
+	}
+	return token, t, nil
+}
+
+// Authenticate returns the API token matching token. The returned bool is
+// false if there's none.
+func (db *DB) Authenticate(ctx context.Context, token string) (types.Token, bool, error) {
+	var t types.Token
+	row := db.getDbh(ctx).QueryRowContext(
+		ctx,
+		"SELECT id, name, scope, created_at FROM api_tokens WHERE hash = ?",
+		hashToken(token),
+	)
+	if err := row.Scan(&t.Id, &t.Name, &t.Scope, &t.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return types.Token{}, false, nil
+		}
+		return types.Token{}, false, fmt.Errorf("error looking up token: %w", err)
+	}
+	return t, true, nil
+}
+
+// Tokens returns the API tokens, oldest first
+func (db *DB) Tokens(ctx context.Context) ([]types.Token, error) {
+	rows, err := db.getDbh(ctx).QueryContext(
+		ctx,
+		"SELECT id, name, scope, created_at FROM api_tokens ORDER BY created_at ASC, id ASC",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tokens: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]types.Token, 0)
+	for rows.Next() {
+		var t types.Token
+		if err := rows.Scan(&t.Id, &t.Name, &t.Scope, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		t.CreatedAt = t.CreatedAt.In(db.loc)
+		result = append(result, t)
+	}
+
+	return result, rows.Err()
+}
+
+// RevokeToken deletes the API token with the given id
+func (db *DB) RevokeToken(ctx context.Context, id string) error {
+	res, err := db.getDbh(ctx).ExecContext(ctx, "DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("error revoking token %q: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no token with id %q", id)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -3,10 +3,13 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/fatcatfablab/doorbot2/achievements"
 	"github.com/fatcatfablab/doorbot2/types"
 )
 
@@ -28,7 +31,7 @@ func getDb(t *testing.T, dbName string) *DB {
 		t.Fatalf("can't create database %s: %s", dbName, err)
 	}
 
-	db, err := New(dsn+dbName, tz)
+	db, err := New(dsn+dbName, tz, achievements.Default())
 	if err != nil {
 		t.Fatalf("can't connect to test db: %s", err)
 	}
@@ -75,7 +78,7 @@ func TestBumpStats(t *testing.T) {
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			got := bumpStats(prevStats, tt.want.Last)
+			got := BumpStats(prevStats, tt.want.Last)
 			if got != tt.want {
 				log.Printf("want: %+v", tt.want)
 				log.Printf("got:  %+v", got)
@@ -137,6 +140,7 @@ func TestAddRecord(t *testing.T) {
 	defer db.Close()
 
 	loc := db.loc
+	prev := types.Stats{Name: username}
 	for _, tt := range []struct {
 		name   string
 		record types.AccessRecord
@@ -193,21 +197,29 @@ func TestAddRecord(t *testing.T) {
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			got, bumped, err := db.AddRecord(ctx, tt.record)
+			v, err := db.AddRecord(ctx, tt.record)
 			if err != nil {
 				t.Fatalf("error adding record: %s", err)
 			}
 
-			if bumped != tt.bumped {
+			if v.Bumped != tt.bumped {
 				t.Error("wrong bump detection")
 			}
 
+			got := v.Stats
 			got.Last = got.Last.In(tt.want.Last.Location())
 			if tt.want != got {
 				log.Printf("want: %+v", tt.want)
 				log.Printf("got : %+v", got)
 				t.Error("stats differ")
 			}
+			v.Prev.Last = v.Prev.Last.In(loc)
+			if !prev.Last.IsZero() && v.Prev != prev {
+				log.Printf("want: %+v", prev)
+				log.Printf("got : %+v", v.Prev)
+				t.Error("previous stats differ")
+			}
+			prev = tt.want
 		})
 	}
 }
@@ -229,7 +241,7 @@ func TestDumpHistory(t *testing.T) {
 		{Timestamp: time.Date(2020, 1, 9, 12, 0, 0, 0, loc), Name: username, AccessGranted: false},
 	}
 	for _, r := range want {
-		_, _, err := db.AddRecord(ctx, r)
+		_, err := db.AddRecord(ctx, r)
 		if err != nil {
 			t.Fatalf("unexpected error adding record: %s", err)
 		}
@@ -280,7 +292,7 @@ func TestRecompute(t *testing.T) {
 		{Timestamp: time.Date(2020, 1, 9, 12, 0, 0, 0, loc), Name: username, AccessGranted: false},
 	}
 	for _, r := range records {
-		_, _, err := db.AddRecord(ctx, r)
+		_, err := db.AddRecord(ctx, r)
 		if err != nil {
 			t.Fatalf("unexpected error adding record: %s", err)
 		}
@@ -304,4 +316,655 @@ func TestRecompute(t *testing.T) {
 		log.Printf("got : %+v", got)
 		t.Errorf("stats differ")
 	}
+
+	if _, err := db.Recompute(ctx, "Nobody"); !errors.Is(err, ErrUnknownMember) {
+		t.Errorf("unexpected error recomputing an unknown member: %v", err)
+	}
+}
+
+func TestArrivals(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_arrivals")
+	defer db.Close()
+
+	loc := db.loc
+	for _, r := range []types.AccessRecord{
+		{Timestamp: time.Date(2020, 1, 1, 12, 0, 0, 0, loc), Name: "A", AccessGranted: true},
+		{Timestamp: time.Date(2020, 1, 2, 9, 0, 0, 0, loc), Name: "B", AccessGranted: false},
+		{Timestamp: time.Date(2020, 1, 2, 10, 0, 0, 0, loc), Name: "B", AccessGranted: true},
+		{Timestamp: time.Date(2020, 1, 2, 11, 0, 0, 0, loc), Name: "A", AccessGranted: true},
+		{Timestamp: time.Date(2020, 1, 2, 12, 0, 0, 0, loc), Name: "B", AccessGranted: true},
+		{Timestamp: time.Date(2020, 1, 2, 13, 0, 0, 0, loc), Name: "C", AccessGranted: false},
+	} {
+		if _, err := db.AddRecord(ctx, r); err != nil {
+			t.Fatalf("unexpected error adding record: %s", err)
+		}
+	}
+
+	want := []types.AccessRecord{
+		{Timestamp: time.Date(2020, 1, 2, 10, 0, 0, 0, loc), Name: "B", AccessGranted: true},
+		{Timestamp: time.Date(2020, 1, 2, 11, 0, 0, 0, loc), Name: "A", AccessGranted: true},
+	}
+
+	got, err := db.Arrivals(ctx, db.StartOfDay(time.Date(2020, 1, 2, 15, 0, 0, 0, loc)))
+	if err != nil {
+		t.Fatalf("error getting arrivals: %s", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected number of arrivals: %d", len(got))
+	}
+	for i := range got {
+		got[i].Timestamp = got[i].Timestamp.In(loc)
+		if got[i] != want[i] {
+			log.Printf("want: %+v", want[i])
+			log.Printf("got : %+v", got[i])
+			t.Errorf("arrivals differ")
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_history")
+	defer db.Close()
+
+	loc := db.loc
+	records := []types.AccessRecord{
+		{Timestamp: time.Date(2020, 1, 1, 12, 0, 0, 0, loc), Name: "A", AccessGranted: true},
+		{Timestamp: time.Date(2020, 1, 2, 10, 0, 0, 0, loc), Name: "B", AccessGranted: true},
+		{Timestamp: time.Date(2020, 1, 2, 11, 0, 0, 0, loc), Name: "A", AccessGranted: false},
+		{Timestamp: time.Date(2020, 1, 3, 0, 0, 0, 0, loc), Name: "B", AccessGranted: true},
+	}
+	for _, r := range records {
+		if _, err := db.AddRecord(ctx, r); err != nil {
+			t.Fatalf("unexpected error adding record: %s", err)
+		}
+	}
+
+	got, err := db.History(ctx, time.Date(2020, 1, 2, 0, 0, 0, 0, loc), time.Date(2020, 1, 3, 0, 0, 0, 0, loc))
+	if err != nil {
+		t.Fatalf("error getting history: %s", err)
+	}
+
+	want := records[1:3]
+	if len(got) != len(want) {
+		t.Fatalf("unexpected number of records: %d", len(got))
+	}
+	for i := range got {
+		got[i].Timestamp = got[i].Timestamp.In(loc)
+		if got[i] != want[i] {
+			log.Printf("want: %+v", want[i])
+			log.Printf("got : %+v", got[i])
+			t.Errorf("records differ")
+		}
+	}
+}
+
+func TestTop(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_top")
+	defer db.Close()
+
+	ttime := time.Date(2025, 1, 17, 13, 0, 0, 0, db.loc)
+	for _, s := range []types.Stats{
+		{Name: "X", Total: 9, Streak: 8, Last: ttime},
+		{Name: "Y", Total: 6, Streak: 1, Last: ttime},
+		{Name: "Z", Total: 12, Streak: 1, Last: ttime},
+		{Name: "W", Total: 9, Streak: 1, Last: ttime},
+	} {
+		if _, err := db.Update(ctx, s); err != nil {
+			t.Fatalf("error updating db: %s", err)
+		}
+	}
+
+	got, err := db.Top(ctx, 3)
+	if err != nil {
+		t.Fatalf("error getting top: %s", err)
+	}
+
+	want := []string{"Z", "W", "X"}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected number of members: %d", len(got))
+	}
+	for i := range got {
+		if got[i].Name != want[i] {
+			t.Errorf("unexpected member at %d: %s. Wanted %s", i, got[i].Name, want[i])
+		}
+	}
+}
+
+func TestSlackUsers(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_slack_users")
+	defer db.Close()
+
+	if err := db.SetSlackUser(ctx, username, "U1"); err != nil {
+		t.Fatalf("error setting slack user: %s", err)
+	}
+	if err := db.SetSlackUser(ctx, username, "U2"); err != nil {
+		t.Fatalf("error replacing slack user: %s", err)
+	}
+	if err := db.SetSlackUser(ctx, "Other", "U2"); err == nil {
+		t.Errorf("a slack user can't be mapped to two members")
+	}
+
+	if id, err := db.SlackUser(ctx, username); err != nil || id != "U2" {
+		t.Errorf("unexpected slack user %q (err: %v)", id, err)
+	}
+	if name, err := db.MemberBySlackUser(ctx, "U2"); err != nil || name != username {
+		t.Errorf("unexpected member %q (err: %v)", name, err)
+	}
+	if id, err := db.SlackUser(ctx, "Unknown"); err != nil || id != "" {
+		t.Errorf("unexpected slack user for unknown member %q (err: %v)", id, err)
+	}
+
+	if err := db.DeleteSlackUser(ctx, username); err != nil {
+		t.Fatalf("error deleting slack user: %s", err)
+	}
+	users, err := db.SlackUsers(ctx)
+	if err != nil {
+		t.Fatalf("error listing slack users: %s", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("unexpected slack users after delete: %v", users)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_announce")
+	defer db.Close()
+
+	if a, err := db.Announce(ctx, username); err != nil || a != types.AnnouncePublic {
+		t.Errorf("unexpected default preference %q (err: %v)", a, err)
+	}
+	for _, want := range []types.Announce{types.AnnounceNever, types.AnnounceAchievements} {
+		if err := db.SetAnnounce(ctx, username, want); err != nil {
+			t.Fatalf("error setting preference: %s", err)
+		}
+		if got, err := db.Announce(ctx, username); err != nil || got != want {
+			t.Errorf("unexpected preference %q, want %q (err: %v)", got, want, err)
+		}
+		optedOut, err := db.OptedOut(ctx)
+		if err != nil {
+			t.Fatalf("error getting opted out members: %s", err)
+		}
+		if optedOut[username] != (want == types.AnnounceNever) {
+			t.Errorf("unexpected opted out members with %q: %v", want, optedOut)
+		}
+	}
+}
+
+func TestDeferredArrivals(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_deferred_arrivals")
+	defer db.Close()
+
+	ts := time.Date(2025, 1, 20, 23, 0, 0, 0, db.Loc())
+	want := []types.Arrival{
+		{Record: types.AccessRecord{Timestamp: ts, Name: username, AccessGranted: true}, Stats: types.Stats{Name: username, Total: 3}},
+		{Record: types.AccessRecord{Timestamp: ts.Add(time.Hour), Name: "Other", AccessGranted: true}, Stats: types.Stats{Name: "Other", Total: 1}, DaysAway: 4},
+	}
+	for _, a := range slices.Backward(want) {
+		if err := db.DeferArrival(ctx, a); err != nil {
+			t.Fatalf("error deferring arrival: %s", err)
+		}
+	}
+
+	got, err := db.DeferredArrivals(ctx)
+	if err != nil {
+		t.Fatalf("error getting deferred arrivals: %s", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected deferred arrivals %+v", got)
+	}
+	for i := range want {
+		if got[i].Stats != want[i].Stats || got[i].DaysAway != want[i].DaysAway || !got[i].Record.Timestamp.Equal(want[i].Record.Timestamp) {
+			log.Printf("want: %+v", want[i])
+			log.Printf("got : %+v", got[i])
+			t.Errorf("deferred arrivals differ")
+		}
+	}
+
+	if err := db.ClearDeferredArrivals(ctx); err != nil {
+		t.Fatalf("error clearing deferred arrivals: %s", err)
+	}
+	if got, err := db.DeferredArrivals(ctx); err != nil || len(got) != 0 {
+		t.Errorf("unexpected deferred arrivals after clearing %+v (err: %v)", got, err)
+	}
+}
+
+func TestSlackThread(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_slack_thread")
+	defer db.Close()
+
+	const day, channel = "2025-01-20", "#general"
+	if _, ok, err := db.SlackThread(ctx, day, channel); err != nil || ok {
+		t.Errorf("unexpected thread before storing one (err: %v)", err)
+	}
+
+	for _, want := range []types.SlackThread{
+		{ChannelId: "C1", Ts: "1737410400.000100"},
+		{ChannelId: "C2", Ts: "1737410400.000200"},
+	} {
+		if err := db.SetSlackThread(ctx, day, channel, want); err != nil {
+			t.Fatalf("error storing thread: %s", err)
+		}
+		got, ok, err := db.SlackThread(ctx, day, channel)
+		if err != nil || !ok || got != want {
+			log.Printf("want: %+v", want)
+			log.Printf("got : %+v", got)
+			t.Errorf("threads differ (err: %v)", err)
+		}
+	}
+
+	if _, ok, err := db.SlackThread(ctx, "2025-01-21", channel); err != nil || ok {
+		t.Errorf("unexpected thread for another day (err: %v)", err)
+	}
+
+	for _, tt := range []struct {
+		day   string
+		names []string
+		want  uint
+	}{
+		{day, []string{username}, 1},
+		{day, []string{"Other", username}, 2},
+		{"2025-01-21", []string{username}, 1},
+	} {
+		if got, err := db.AddSlackThreadAttendees(ctx, tt.day, channel, tt.names); err != nil || got != tt.want {
+			t.Errorf("unexpected attendees %d on %s, want %d (err: %v)", got, tt.day, tt.want, err)
+		}
+	}
+}
+
+func TestDaysBetween(t *testing.T) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+
+	for _, tt := range []struct {
+		from time.Time
+		to   time.Time
+		want uint
+	}{
+		{time.Date(2025, 1, 20, 8, 0, 0, 0, loc), time.Date(2025, 1, 20, 22, 0, 0, 0, loc), 0},
+		{time.Date(2025, 1, 20, 23, 0, 0, 0, loc), time.Date(2025, 1, 21, 1, 0, 0, 0, loc), 1},
+		{time.Date(2025, 1, 20, 8, 0, 0, 0, loc), time.Date(2025, 4, 20, 8, 0, 0, 0, loc), 90},
+		// Across the DST change
+		{time.Date(2025, 3, 8, 23, 30, 0, 0, loc), time.Date(2025, 3, 10, 0, 30, 0, 0, loc), 2},
+		// In a different location, same instant as 2025-01-20 22:00 in New York
+		{time.Date(2025, 1, 21, 3, 0, 0, 0, time.UTC), time.Date(2025, 1, 21, 8, 0, 0, 0, loc), 1},
+		{time.Date(2025, 1, 21, 0, 0, 0, 0, loc), time.Date(2025, 1, 20, 0, 0, 0, 0, loc), 0},
+	} {
+		if got := DaysBetween(tt.from, tt.to); got != tt.want {
+			t.Errorf("unexpected days between %s and %s: %d, want %d", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestAchievements(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_achievements")
+	defer db.Close()
+
+	saturday := time.Date(2025, 1, 18, 6, 0, 0, 0, db.Loc())
+	for _, tt := range []struct {
+		name string
+		ts   time.Time
+		want []string
+	}{
+		{"Early bird", saturday, []string{"early-bird"}},
+		{"Early bird again", saturday.Add(30 * time.Minute), nil},
+		{"Weekend warrior, not an early bird anymore", saturday.AddDate(0, 0, 1), []string{"weekend-warrior"}},
+		{"Night owl on a later visit the same day", saturday.AddDate(0, 0, 1).Add(17 * time.Hour), []string{"night-owl"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := db.AddRecord(ctx, types.AccessRecord{Timestamp: tt.ts, Name: username, AccessGranted: true})
+			if err != nil {
+				t.Fatalf("error adding record: %s", err)
+			}
+
+			var got []string
+			for _, a := range v.Awards {
+				got = append(got, a.Id)
+				if !a.AwardedAt.Equal(tt.ts) {
+					t.Errorf("unexpected award time %s", a.AwardedAt)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				log.Printf("want: %v", tt.want)
+				log.Printf("got : %v", got)
+				t.Errorf("achievements differ")
+			}
+		})
+	}
+
+	all, err := db.Achievements(ctx, username)
+	if err != nil {
+		t.Fatalf("error listing achievements: %s", err)
+	}
+	if len(all) != 3 || all[0].Id != "early-bird" || all[2].Id != "night-owl" {
+		t.Errorf("unexpected achievements %+v", all)
+	}
+
+	sunday := saturday.AddDate(0, 0, 1)
+	between, err := db.AwardedBetween(ctx, sunday, sunday.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("error listing awards: %s", err)
+	}
+	var got []string
+	for _, a := range between {
+		if a.Name != username {
+			t.Errorf("unexpected member %q", a.Name)
+		}
+		got = append(got, a.Id)
+	}
+	if want := []string{"weekend-warrior", "night-owl"}; !slices.Equal(got, want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %v", got)
+		t.Errorf("awards between differ")
+	}
+}
+
+func TestBadgeAwards(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_badge_awards")
+	defer db.Close()
+
+	// Noon on weekdays only, so no other achievement gets in the way
+	var visits []time.Time
+	for d := time.Date(2025, 3, 3, 12, 0, 0, 0, db.Loc()); len(visits) < 7; d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			visits = append(visits, d)
+		}
+	}
+
+	var got []string
+	for _, ts := range visits {
+		for _, r := range []types.AccessRecord{
+			{Timestamp: ts, Name: username, AccessGranted: true},
+			// A later visit the same day doesn't award anything again
+			{Timestamp: ts.Add(time.Hour), Name: username, AccessGranted: true},
+		} {
+			v, err := db.AddRecord(ctx, r)
+			if err != nil {
+				t.Fatalf("error adding record: %s", err)
+			}
+			for _, a := range v.Awards {
+				got = append(got, a.Id)
+			}
+		}
+	}
+	want := []string{"streak-4", "total-6"}
+	if !slices.Equal(got, want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %v", got)
+		t.Errorf("awards differ")
+	}
+
+	before, err := db.Achievements(ctx, username)
+	if err != nil {
+		t.Fatalf("error listing achievements: %s", err)
+	}
+	if _, err := db.Recompute(ctx, username); err != nil {
+		t.Fatalf("error recomputing: %s", err)
+	}
+	after, err := db.Achievements(ctx, username)
+	if err != nil {
+		t.Fatalf("error listing achievements: %s", err)
+	}
+	if !slices.EqualFunc(before, after, func(a, b types.Achievement) bool {
+		return a.Id == b.Id && a.AwardedAt.Equal(b.AwardedAt)
+	}) {
+		log.Printf("want: %+v", before)
+		log.Printf("got : %+v", after)
+		t.Errorf("recomputed awards differ")
+	}
+}
+
+func TestRecomputeAchievements(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_recompute_achievements")
+	defer db.Close()
+
+	// Every day of March, earning every-weekday-2025-03 and weekend-warrior,
+	// and a visit a year later for the anniversary
+	var visits []time.Time
+	for d := time.Date(2025, 3, 1, 12, 0, 0, 0, db.Loc()); d.Month() == time.March; d = d.AddDate(0, 0, 1) {
+		visits = append(visits, d)
+	}
+	visits = append(visits, time.Date(2026, 3, 2, 12, 0, 0, 0, db.Loc()))
+	for _, ts := range visits {
+		if _, err := db.AddRecord(ctx, types.AccessRecord{Timestamp: ts, Name: username, AccessGranted: true}); err != nil {
+			t.Fatalf("error adding record: %s", err)
+		}
+	}
+
+	before, err := db.Achievements(ctx, username)
+	if err != nil {
+		t.Fatalf("error listing achievements: %s", err)
+	}
+	var ids []string
+	for _, a := range before {
+		ids = append(ids, a.Id)
+	}
+	for _, id := range []string{"weekend-warrior", "every-weekday-2025-03", "anniversary-1"} {
+		if !slices.Contains(ids, id) {
+			t.Errorf("%s not awarded: %v", id, ids)
+		}
+	}
+
+	if _, err := db.Recompute(ctx, username); err != nil {
+		t.Fatalf("error recomputing: %s", err)
+	}
+	after, err := db.Achievements(ctx, username)
+	if err != nil {
+		t.Fatalf("error listing achievements: %s", err)
+	}
+	if !slices.EqualFunc(before, after, func(a, b types.Achievement) bool {
+		return a.Id == b.Id && a.AwardedAt.Equal(b.AwardedAt)
+	}) {
+		log.Printf("want: %+v", before)
+		log.Printf("got : %+v", after)
+		t.Errorf("recomputed awards differ")
+	}
+}
+
+func TestSyncBadges(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_sync_badges")
+	defer db.Close()
+
+	last := time.Date(2025, 3, 3, 12, 0, 0, 0, db.Loc())
+	if _, err := db.Update(ctx, types.Stats{Name: username, Total: 40, Streak: 2, Last: last}); err != nil {
+		t.Fatalf("error updating stats: %s", err)
+	}
+
+	for _, want := range []int{2, 0} {
+		synced, err := db.SyncBadges(ctx)
+		if err != nil {
+			t.Fatalf("error syncing badges: %s", err)
+		}
+		if synced != want {
+			t.Errorf("unexpected number of badges synced: %d, want %d", synced, want)
+		}
+	}
+
+	got, err := db.Achievements(ctx, username)
+	if err != nil {
+		t.Fatalf("error listing achievements: %s", err)
+	}
+	if len(got) != 2 || got[0].Id != "total-30" || got[1].Id != "total-6" || !got[0].AwardedAt.Equal(last) {
+		t.Errorf("unexpected achievements %+v", got)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_tokens")
+	defer db.Close()
+
+	token, created, err := db.CreateToken(ctx, "ci", types.ScopeRead)
+	if err != nil {
+		t.Fatalf("error creating token: %s", err)
+	}
+
+	got, ok, err := db.Authenticate(ctx, token)
+	if err != nil || !ok || got.Id != created.Id || got.Scope != types.ScopeRead {
+		t.Errorf("unexpected token %+v (found: %t, err: %v)", got, ok, err)
+	}
+	if _, ok, _ := db.Authenticate(ctx, created.Id+".wrong"); ok {
+		t.Errorf("wrong token authenticated")
+	}
+
+	tokens, err := db.Tokens(ctx)
+	if err != nil || len(tokens) != 1 || tokens[0].Name != "ci" {
+		t.Errorf("unexpected tokens %+v (err: %v)", tokens, err)
+	}
+
+	if err := db.RevokeToken(ctx, created.Id); err != nil {
+		t.Fatalf("error revoking token: %s", err)
+	}
+	if _, ok, _ := db.Authenticate(ctx, token); ok {
+		t.Errorf("revoked token authenticated")
+	}
+	if err := db.RevokeToken(ctx, created.Id); err == nil {
+		t.Errorf("expected an error revoking an unknown token")
+	}
+}
+
+func TestMemberAdmin(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "doorbot2_test_member_admin")
+	defer db.Close()
+
+	day := time.Date(2025, 1, 20, 12, 0, 0, 0, db.Loc())
+	for _, r := range []types.AccessRecord{
+		{Timestamp: day, Name: "Johnny", AccessGranted: true},
+		{Timestamp: day.AddDate(0, 0, 1), Name: "Johnny", AccessGranted: true},
+		{Timestamp: day.AddDate(0, 0, 2), Name: "J. Melavo", AccessGranted: true},
+		{Timestamp: day.AddDate(0, 0, 3), Name: "Dupe", AccessGranted: true},
+	} {
+		if _, err := db.AddRecord(ctx, r); err != nil {
+			t.Fatalf("error adding record: %s", err)
+		}
+	}
+	if err := db.SetSlackUser(ctx, "J. Melavo", "U1"); err != nil {
+		t.Fatalf("error setting slack user: %s", err)
+	}
+	const threadDay, channel = "2025-01-20", "#general"
+	if _, err := db.AddSlackThreadAttendees(ctx, threadDay, channel, []string{"Johnny", "J. Melavo", "Dupe"}); err != nil {
+		t.Fatalf("error adding attendees: %s", err)
+	}
+	deferred := types.Arrival{
+		Record: types.AccessRecord{Timestamp: day.AddDate(0, 0, 4), Name: "Johnny", AccessGranted: true},
+		Stats:  types.Stats{Name: "Johnny"},
+	}
+	if err := db.DeferArrival(ctx, deferred); err != nil {
+		t.Fatalf("error deferring arrival: %s", err)
+	}
+
+	if err := db.RenameMember(ctx, "Johnny", "Johnny Melavo"); err != nil {
+		t.Fatalf("error renaming: %s", err)
+	}
+	if err := db.RenameMember(ctx, "Johnny Melavo", "Dupe"); !errors.Is(err, ErrMemberExists) {
+		t.Errorf("unexpected error renaming to an existing member: %v", err)
+	}
+	if err := db.RenameMember(ctx, "Nobody", "Somebody"); !errors.Is(err, ErrUnknownMember) {
+		t.Errorf("unexpected error renaming an unknown member: %v", err)
+	}
+	// Names without history are in use too, like one only linked to Slack
+	if err := db.SetSlackUser(ctx, "Not yet in", "U2"); err != nil {
+		t.Fatalf("error setting slack user: %s", err)
+	}
+	if err := db.RenameMember(ctx, "Johnny Melavo", "Not yet in"); !errors.Is(err, ErrMemberExists) {
+		t.Errorf("unexpected error renaming to a linked name: %v", err)
+	}
+	if err := db.DeleteSlackUser(ctx, "Not yet in"); err != nil {
+		t.Fatalf("error deleting slack user: %s", err)
+	}
+	if _, err := db.MergeMember(ctx, "Dupe", "Dupe"); !errors.Is(err, ErrSameMember) {
+		t.Errorf("unexpected error merging a member into itself: %v", err)
+	}
+
+	got, err := db.MergeMember(ctx, "J. Melavo", "Johnny Melavo")
+	if err != nil {
+		t.Fatalf("error merging: %s", err)
+	}
+	got.Last = got.Last.In(db.Loc())
+	want := types.Stats{Name: "Johnny Melavo", Total: 3, Streak: 3, Last: day.AddDate(0, 0, 2)}
+	if got != want {
+		log.Printf("want: %+v", want)
+		log.Printf("got : %+v", got)
+		t.Errorf("stats differ")
+	}
+	if id, err := db.SlackUser(ctx, "Johnny Melavo"); err != nil || id != "U1" {
+		t.Errorf("unexpected slack user %q (err: %v)", id, err)
+	}
+	if n, err := db.AddSlackThreadAttendees(ctx, threadDay, channel, nil); err != nil || n != 2 {
+		t.Errorf("unexpected attendees after merging %d (err: %v)", n, err)
+	}
+	arrivals, err := db.DeferredArrivals(ctx)
+	if err != nil || len(arrivals) != 1 || arrivals[0].Stats.Name != "Johnny Melavo" || arrivals[0].Record.Name != "Johnny Melavo" {
+		t.Errorf("unexpected deferred arrivals %+v (err: %v)", arrivals, err)
+	}
+
+	if err := db.DeleteMember(ctx, "Dupe"); err != nil {
+		t.Fatalf("error deleting: %s", err)
+	}
+	members, err := db.Members(ctx)
+	if err != nil {
+		t.Fatalf("error listing members: %s", err)
+	}
+	if !slices.Equal(members, []string{"Johnny Melavo"}) {
+		t.Errorf("unexpected members %v", members)
+	}
+	if n, err := db.AddSlackThreadAttendees(ctx, threadDay, channel, nil); err != nil || n != 1 {
+		t.Errorf("unexpected attendees after deleting %d (err: %v)", n, err)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	ctx := context.Background()
+	db := getDb(t, "test_check_schema")
+	defer db.Close()
+
+	if err := db.Ping(ctx); err != nil {
+		t.Errorf("error pinging: %s", err)
+	}
+	if err := db.CheckSchema(ctx); err != nil {
+		t.Errorf("unexpected schema error: %s", err)
+	}
+
+	// Columns left over by older builds are dropped
+	if _, err := db.db.Exec("ALTER TABLE slack_threads ADD COLUMN attendees INT UNSIGNED NOT NULL"); err != nil {
+		t.Fatalf("error adding column: %s", err)
+	}
+	if err := db.initialize(); err != nil {
+		t.Fatalf("error initializing: %s", err)
+	}
+	var n int
+	err := db.db.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.columns " +
+			"WHERE table_schema = DATABASE() AND table_name = 'slack_threads' AND column_name = 'attendees'",
+	).Scan(&n)
+	if err != nil || n != 0 {
+		t.Errorf("column wasn't dropped (err: %v)", err)
+	}
+	if err := db.SetSlackThread(ctx, "2025-01-20", "#general", types.SlackThread{ChannelId: "C1", Ts: "1"}); err != nil {
+		t.Errorf("error storing thread after upgrading: %s", err)
+	}
+
+	// A newer build upgraded the tables, and initializing doesn't undo it
+	if _, err := db.db.Exec("UPDATE schema_version SET version = ?", schemaVersion+1); err != nil {
+		t.Fatalf("error bumping schema version: %s", err)
+	}
+	if err := db.initialize(); err != nil {
+		t.Fatalf("error initializing: %s", err)
+	}
+	if err := db.CheckSchema(ctx); err == nil {
+		t.Errorf("expected a schema error")
+	}
 }
@@ -0,0 +1,141 @@
+package events
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	// Events buffered per subscriber. Subscribers falling further behind are
+	// dropped, and can catch up by subscribing again from their last event.
+	subscriberBuffer = 16
+)
+
+var (
+	ErrTooManySubscribers = errors.New("too many subscribers")
+	ErrClosed             = errors.New("broker closed")
+)
+
+// Event is an arrival published to subscribers. Ids grow with time, even
+// across restarts.
+type Event struct {
+	Id      uint64
+	Arrival types.Arrival
+}
+
+// Broker fans out arrivals to subscribers in the same process, and keeps the
+// most recent ones so subscribers can resume after reconnecting
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	max    int
+	recent []Event
+	keep   int
+	lastId uint64
+	closed bool
+	// Events after this id are all in recent: it's when the broker started,
+	// or the id of the last event dropped from recent
+	since uint64
+
+	now func() time.Time
+}
+
+// NewBroker returns a broker for up to maxSubscribers at a time, keeping the
+// last keep events around
+func NewBroker(maxSubscribers, keep int) *Broker {
+	started := uint64(time.Now().UnixNano())
+	return &Broker{
+		subs:   make(map[chan Event]struct{}),
+		max:    maxSubscribers,
+		keep:   keep,
+		lastId: started,
+		since:  started,
+		now:    time.Now,
+	}
+}
+
+// Publish sends an arrival to every subscriber, and returns the event it was
+// sent as
+func (b *Broker) Publish(a types.Arrival) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastId = max(b.lastId+1, uint64(b.now().UnixNano()))
+	e := Event{Id: b.lastId, Arrival: a}
+	b.recent = append(b.recent, e)
+	if len(b.recent) > b.keep {
+		b.since = b.recent[len(b.recent)-b.keep-1].Id
+		b.recent = b.recent[len(b.recent)-b.keep:]
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return e
+}
+
+// Subscribe returns the recent events after lastId, followed by a channel
+// with the ones to come. The channel is closed when cancel is called, or if
+// the subscriber falls behind. A zero lastId skips the recent events.
+// complete is unset when events after lastId may have been published before
+// the recent ones, like before a restart, so they have to be looked up
+// elsewhere.
+func (b *Broker) Subscribe(lastId uint64) (missed []Event, complete bool, events <-chan Event, cancel func(), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, false, nil, nil, ErrClosed
+	}
+	if len(b.subs) >= b.max {
+		return nil, false, nil, nil, ErrTooManySubscribers
+	}
+
+	complete = lastId == 0 || lastId >= b.since
+	if lastId > 0 {
+		for _, e := range b.recent {
+			if e.Id > lastId {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subs[ch] = struct{}{}
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return missed, complete, ch, cancel, nil
+}
+
+// Close disconnects every subscriber and turns away new ones, so streams
+// don't hold up shutting down
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Subscribers returns how many subscribers there are
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
@@ -0,0 +1,101 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+func arrival(name string) types.Arrival {
+	return types.Arrival{Stats: types.Stats{Name: name}}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(2, 2)
+	// Ids grow even if the clock doesn't
+	b.now = func() time.Time { return time.Unix(1, 0) }
+
+	first := b.Publish(arrival("Alice"))
+	missed, complete, ch, cancel, err := b.Subscribe(0)
+	if err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+	if len(missed) != 0 || !complete {
+		t.Errorf("unexpected missed events %+v", missed)
+	}
+
+	second := b.Publish(arrival("Bob"))
+	if second.Id <= first.Id {
+		t.Errorf("ids don't grow: %d, then %d", first.Id, second.Id)
+	}
+	if e := <-ch; e.Arrival.Stats.Name != "Bob" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// Resuming gets what was published after the last event seen, out of
+	// the ones kept
+	b.Publish(arrival("Carol"))
+	missed, complete, _, cancel2, err := b.Subscribe(first.Id)
+	if err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+	if len(missed) != 2 || missed[0].Arrival.Stats.Name != "Bob" || missed[1].Arrival.Stats.Name != "Carol" || !complete {
+		t.Errorf("unexpected missed events %+v", missed)
+	}
+	cancel2()
+
+	// Events from before the kept ones, or before the broker started, may be
+	// missing
+	for _, id := range []uint64{first.Id - 1, 1} {
+		if _, complete, _, cancel, err := b.Subscribe(id); err != nil || complete {
+			t.Errorf("resuming from %d was complete: %v", id, err)
+		} else {
+			cancel()
+		}
+	}
+
+	_, _, _, cancel2, err = b.Subscribe(first.Id)
+	if err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+
+	if _, _, _, _, err := b.Subscribe(0); !errors.Is(err, ErrTooManySubscribers) {
+		t.Errorf("unexpected error over the subscriber limit: %v", err)
+	}
+	cancel2()
+	cancel2()
+	if n := b.Subscribers(); n != 1 {
+		t.Errorf("unexpected number of subscribers %d", n)
+	}
+
+	// Subscribers falling behind are dropped
+	<-ch
+	for range subscriberBuffer + 1 {
+		b.Publish(arrival("Dave"))
+	}
+	for range ch {
+	}
+	if n := b.Subscribers(); n != 0 {
+		t.Errorf("slow subscriber wasn't dropped")
+	}
+	cancel()
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := NewBroker(2, 2)
+	_, _, ch, cancel, err := b.Subscribe(0)
+	if err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+
+	b.Close()
+	if _, ok := <-ch; ok {
+		t.Errorf("subscriber wasn't disconnected")
+	}
+	cancel()
+	if _, _, _, _, err := b.Subscribe(0); !errors.Is(err, ErrClosed) {
+		t.Errorf("unexpected error subscribing after closing: %v", err)
+	}
+}
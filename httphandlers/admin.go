@@ -0,0 +1,158 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+type mergeRequest struct {
+	Into string `json:"into"`
+}
+
+func (h handlers) handleAdminApi(mux *http.ServeMux) {
+	prefix := apiPrefix + "/admin/members/{name}"
+	mux.HandleFunc("GET "+prefix+"/dump", h.requireScope(types.ScopeAdmin, h.adminDump))
+	mux.HandleFunc("POST "+prefix+"/recompute", h.requireScope(types.ScopeAdmin, h.adminRecompute))
+	mux.HandleFunc("POST "+prefix+"/rename", h.requireScope(types.ScopeAdmin, h.adminRename))
+	mux.HandleFunc("POST "+prefix+"/merge", h.requireScope(types.ScopeAdmin, h.adminMerge))
+	mux.HandleFunc("DELETE "+prefix, h.requireScope(types.ScopeAdmin, h.adminDelete))
+}
+
+// requireScope only lets requests through to next when they carry a bearer
+// token allowing scope
+func (h handlers) requireScope(scope types.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = ""
+		}
+		t, ok := h.authenticate(w, req, token)
+		if !ok {
+			return
+		}
+		if !t.Scope.Allows(scope) {
+			writeError(w, http.StatusForbidden, "token doesn't have the "+string(scope)+" scope")
+			return
+		}
+
+		next(w, req)
+	}
+}
+
+// authenticate looks up a token, writing the error response if it's missing
+// or invalid
+func (h handlers) authenticate(w http.ResponseWriter, req *http.Request, token string) (types.Token, bool) {
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "missing bearer token")
+		return types.Token{}, false
+	}
+
+	t, ok, err := h.db.Authenticate(req.Context(), token)
+	if err != nil {
+		slog.Error("error authenticating request", "err", err)
+		writeError(w, http.StatusInternalServerError, "error authenticating request")
+		return types.Token{}, false
+	}
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return types.Token{}, false
+	}
+	return t, true
+}
+
+func (h handlers) adminDump(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	records, err := h.db.DumpHistory(req.Context(), name)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	if len(records) == 0 {
+		writeError(w, http.StatusNotFound, "unknown member")
+		return
+	}
+	for i := range records {
+		records[i].Timestamp = records[i].Timestamp.In(h.db.Loc())
+	}
+	writeJSON(w, http.StatusOK, historyPage{Records: records})
+}
+
+func (h handlers) adminRecompute(w http.ResponseWriter, req *http.Request) {
+	s, err := h.db.Recompute(req.Context(), req.PathValue("name"))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	slog.Info("Recomputed through the API", "member", s.Name)
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (h handlers) adminRename(w http.ResponseWriter, req *http.Request) {
+	var body renameRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Name == "" {
+		writeError(w, http.StatusBadRequest, `expected a body like {"name": "New name"}`)
+		return
+	}
+
+	name := req.PathValue("name")
+	if err := h.db.RenameMember(req.Context(), name, body.Name); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	slog.Info("Renamed through the API", "member", name, "to", body.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h handlers) adminMerge(w http.ResponseWriter, req *http.Request) {
+	var body mergeRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Into == "" {
+		writeError(w, http.StatusBadRequest, `expected a body like {"into": "Other name"}`)
+		return
+	}
+
+	name := req.PathValue("name")
+	s, err := h.db.MergeMember(req.Context(), name, body.Into)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	slog.Info("Merged through the API", "member", name, "into", body.Into)
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (h handlers) adminDelete(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	if err := h.db.DeleteMember(req.Context(), name); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	slog.Info("Deleted through the API", "member", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAdminError maps db errors to a status code
+func writeAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrUnknownMember):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, db.ErrMemberExists):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, db.ErrSameMember):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		slog.Error("error running admin request", "err", err)
+		writeError(w, http.StatusInternalServerError, "error running admin request")
+	}
+}
@@ -0,0 +1,96 @@
+package httphandlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/events"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+func TestAdminApi(t *testing.T) {
+	ctx := context.Background()
+	accessDb := getDb(t, "test_admin_api")
+	defer accessDb.Close()
+
+	day := time.Date(2025, 1, 20, 12, 0, 0, 0, accessDb.Loc())
+	for _, r := range []types.AccessRecord{
+		{Timestamp: day, Name: "Johnny", AccessGranted: true},
+		{Timestamp: day.AddDate(0, 0, 1), Name: "J. Melavo", AccessGranted: true},
+		{Timestamp: day.AddDate(0, 0, 2), Name: "Dupe", AccessGranted: true},
+	} {
+		if _, err := accessDb.AddRecord(ctx, r); err != nil {
+			t.Fatalf("error adding record: %s", err)
+		}
+	}
+
+	readToken, _, err := accessDb.CreateToken(ctx, "reader", types.ScopeRead)
+	if err != nil {
+		t.Fatalf("error creating token: %s", err)
+	}
+	adminToken, _, err := accessDb.CreateToken(ctx, "admin", types.ScopeAdmin)
+	if err != nil {
+		t.Fatalf("error creating token: %s", err)
+	}
+	mux := NewMux(accessDb, nil, WithApi(), WithApiTokens(), WithDashboard(), WithEvents(events.NewBroker(1, 10)))
+
+	for _, tt := range []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		token    string
+		wantCode int
+	}{
+		{"Read without token", http.MethodGet, "/api/v1/members", "", "", http.StatusUnauthorized},
+		{"Read with a wrong token", http.MethodGet, "/api/v1/members", "", "nope", http.StatusUnauthorized},
+		{"Read with read token", http.MethodGet, "/api/v1/members", "", readToken, http.StatusOK},
+		{"Read with admin token", http.MethodGet, "/api/v1/members", "", adminToken, http.StatusOK},
+		{"Read with token in the query", http.MethodGet, "/api/v1/members/Johnny/visits.ics?access_token=" + readToken, "", "", http.StatusOK},
+		{"Admin token in the query", http.MethodGet, "/api/v1/members/Johnny/visits.ics?access_token=" + adminToken, "", "", http.StatusForbidden},
+		{"Token in the query elsewhere", http.MethodGet, "/api/v1/members?access_token=" + readToken, "", "", http.StatusUnauthorized},
+		{"Admin with token in the query", http.MethodGet, "/api/v1/admin/members/Johnny/dump?access_token=" + adminToken, "", "", http.StatusUnauthorized},
+		{"Dashboard without token", http.MethodGet, "/dashboard", "", "", http.StatusUnauthorized},
+		{"Dashboard with read token", http.MethodGet, "/dashboard", "", readToken, http.StatusOK},
+		{"Events without token", http.MethodGet, "/events", "", "", http.StatusUnauthorized},
+		{"Events with admin token in the query", http.MethodGet, "/events?access_token=" + adminToken, "", "", http.StatusForbidden},
+		{"Kiosk without token", http.MethodGet, "/kiosk", "", "", http.StatusUnauthorized},
+		{"Kiosk with token in the query", http.MethodGet, "/kiosk?access_token=" + readToken, "", "", http.StatusOK},
+		{"Admin with read token", http.MethodGet, "/api/v1/admin/members/Johnny/dump", "", readToken, http.StatusForbidden},
+		{"Dump", http.MethodGet, "/api/v1/admin/members/Johnny/dump", "", adminToken, http.StatusOK},
+		{"Dump unknown", http.MethodGet, "/api/v1/admin/members/Nobody/dump", "", adminToken, http.StatusNotFound},
+		{"Rename", http.MethodPost, "/api/v1/admin/members/Johnny/rename", `{"name": "Johnny Melavo"}`, adminToken, http.StatusNoContent},
+		{"Rename to existing", http.MethodPost, "/api/v1/admin/members/Johnny%20Melavo/rename", `{"name": "Dupe"}`, adminToken, http.StatusConflict},
+		{"Rename without body", http.MethodPost, "/api/v1/admin/members/Dupe/rename", "", adminToken, http.StatusBadRequest},
+		{"Merge into itself", http.MethodPost, "/api/v1/admin/members/Dupe/merge", `{"into": "Dupe"}`, adminToken, http.StatusBadRequest},
+		{"Merge", http.MethodPost, "/api/v1/admin/members/J.%20Melavo/merge", `{"into": "Johnny Melavo"}`, adminToken, http.StatusOK},
+		{"Recompute", http.MethodPost, "/api/v1/admin/members/Johnny%20Melavo/recompute", "", adminToken, http.StatusOK},
+		{"Recompute unknown", http.MethodPost, "/api/v1/admin/members/Nobody/recompute", "", adminToken, http.StatusNotFound},
+		{"Delete", http.MethodDelete, "/api/v1/admin/members/Dupe", "", adminToken, http.StatusNoContent},
+		{"Delete again", http.MethodDelete, "/api/v1/admin/members/Dupe", "", adminToken, http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+			if resp.Code != tt.wantCode {
+				t.Errorf("unexpected status %d, want %d: %s", resp.Code, tt.wantCode, resp.Body)
+			}
+		})
+	}
+
+	s, err := accessDb.Get(ctx, "Johnny Melavo")
+	if err != nil {
+		t.Fatalf("error getting stats: %s", err)
+	}
+	if s.Total != 2 || s.Streak != 2 {
+		t.Errorf("unexpected stats after merging %+v", s)
+	}
+}
@@ -0,0 +1,293 @@
+package httphandlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/attendance"
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	apiPrefix       = "/api/v1"
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// apiError is the body of every error returned by the API
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type membersPage struct {
+	Members []types.Stats `json:"members"`
+	// Cursor to pass for the next page. Empty on the last one.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type memberStats struct {
+	types.Stats
+	TotalBadge   badges.Tier         `json:"total_badge"`
+	StreakBadge  badges.Tier         `json:"streak_badge"`
+	Achievements []types.Achievement `json:"achievements"`
+}
+
+type historyPage struct {
+	Records    []types.AccessRecord `json:"records"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+func (h handlers) handleApi(mux *http.ServeMux) {
+	if h.api {
+		mux.HandleFunc("GET "+apiPrefix+"/members", h.read(h.apiMembers))
+		mux.HandleFunc("GET "+apiPrefix+"/members/{name}/stats", h.read(h.apiStats))
+		mux.HandleFunc("GET "+apiPrefix+"/members/{name}/history", h.read(h.apiHistory))
+		mux.HandleFunc("GET "+apiPrefix+"/members/{name}/heatmap.svg", h.read(h.apiHeatmap))
+		mux.HandleFunc("GET "+apiPrefix+"/members/{name}/visits.ics", h.readFeed(h.apiCalendar))
+	}
+	if h.tokens {
+		h.handleAdminApi(mux)
+	}
+	mux.HandleFunc(apiPrefix+"/", func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	})
+}
+
+// read requires a token with the read scope when tokens are enabled
+func (h handlers) read(next http.HandlerFunc) http.HandlerFunc {
+	if !h.tokens {
+		return next
+	}
+	return h.requireScope(types.ScopeRead, next)
+}
+
+// readFeed is like read, but also takes the token as access_token in the
+// query, for clients that can't set headers, like calendar apps and browsers
+// following /events. Tokens in URLs end up in logs and bookmarks, so only read
+// ones are taken there.
+func (h handlers) readFeed(next http.HandlerFunc) http.HandlerFunc {
+	if !h.tokens {
+		return next
+	}
+	header := h.requireScope(types.ScopeRead, next)
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := req.URL.Query().Get("access_token")
+		if token == "" || req.Header.Get("Authorization") != "" {
+			header(w, req)
+			return
+		}
+
+		t, ok := h.authenticate(w, req, token)
+		if !ok {
+			return
+		}
+		if t.Scope != types.ScopeRead {
+			writeError(w, http.StatusForbidden, "only tokens with the read scope can be passed in the query")
+			return
+		}
+		next(w, req)
+	}
+}
+
+// apiMembers lists the stats of every member, sorted by name. The cursor is
+// the name of the first member of the page.
+func (h handlers) apiMembers(w http.ResponseWriter, req *http.Request) {
+	limit, err := pageSize(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// One more than asked for tells whether there's a next page
+	stats, err := h.db.StatsPage(req.Context(), req.URL.Query().Get("cursor"), limit+1)
+	if err != nil {
+		slog.Error("error listing members", "err", err)
+		writeError(w, http.StatusInternalServerError, "error listing members")
+		return
+	}
+
+	page := membersPage{Members: stats}
+	if len(stats) > limit {
+		page.Members, page.NextCursor = stats[:limit], stats[limit].Name
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h handlers) apiStats(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	s, err := h.db.Get(req.Context(), name)
+	if err != nil {
+		slog.Error("error getting stats", "member", name, "err", err)
+		writeError(w, http.StatusInternalServerError, "error getting stats")
+		return
+	}
+	if s.Total == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown member %q", name))
+		return
+	}
+
+	awards, err := h.db.Achievements(req.Context(), name)
+	if err != nil {
+		slog.Error("error getting achievements", "member", name, "err", err)
+		writeError(w, http.StatusInternalServerError, "error getting achievements")
+		return
+	}
+
+	conf := badges.Current()
+	s.Last = s.Last.In(h.db.Loc())
+	tBadge, _ := conf.Total(s.Total)
+	sBadge, _ := conf.Streak(s.Streak)
+	writeJSON(w, http.StatusOK, memberStats{
+		Stats:        s,
+		TotalBadge:   tBadge,
+		StreakBadge:  sBadge,
+		Achievements: awards,
+	})
+}
+
+// apiHistory lists the access records of a member between from (inclusive)
+// and to (exclusive), either being RFC 3339 times or dates. The cursor is the
+// timestamp of the first record of the page.
+func (h handlers) apiHistory(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	limit, err := pageSize(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, err := h.parseTime(q.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid from: %s", err))
+		return
+	}
+	to, err := h.parseTime(q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid to: %s", err))
+		return
+	}
+	if c := q.Get("cursor"); c != "" {
+		cursor, err := time.Parse(time.RFC3339Nano, c)
+		if err != nil || cursor.Before(from) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		from = cursor
+	}
+
+	name := req.PathValue("name")
+	records, err := h.db.MemberHistory(req.Context(), name, from, to, limit+1)
+	if err != nil {
+		slog.Error("error getting history", "member", name, "err", err)
+		writeError(w, http.StatusInternalServerError, "error getting history")
+		return
+	}
+
+	page := historyPage{Records: records}
+	if len(records) > limit {
+		page.Records = records[:limit]
+		page.NextCursor = records[limit].Timestamp.Format(time.RFC3339Nano)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// apiHeatmap renders the visits of a member in the last year, or the year in
+// the query, as an SVG heatmap
+func (h handlers) apiHeatmap(w http.ResponseWriter, req *http.Request) {
+	var year int
+	if y := req.URL.Query().Get("year"); y != "" {
+		var err error
+		if year, err = strconv.Atoi(y); err != nil || year < 1970 || year > 9999 {
+			writeError(w, http.StatusBadRequest, "invalid year")
+			return
+		}
+	}
+
+	days, ok := h.visitDays(w, req)
+	if !ok {
+		return
+	}
+	from, to := attendance.Range(year, time.Now().In(h.db.Loc()))
+	var buf bytes.Buffer
+	if err := attendance.Heatmap(&buf, days, from, to); err != nil {
+		slog.Error("error rendering heatmap", "err", err)
+		writeError(w, http.StatusInternalServerError, "error rendering heatmap")
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	buf.WriteTo(w)
+}
+
+// apiCalendar serves the days a member came in as an iCalendar feed
+func (h handlers) apiCalendar(w http.ResponseWriter, req *http.Request) {
+	days, ok := h.visitDays(w, req)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := attendance.Calendar(&buf, req.PathValue("name"), days, time.Now()); err != nil {
+		slog.Error("error rendering calendar", "err", err)
+		writeError(w, http.StatusInternalServerError, "error rendering calendar")
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// visitDays returns the days the member in the path came in, or writes an
+// error and returns false
+func (h handlers) visitDays(w http.ResponseWriter, req *http.Request) ([]attendance.Day, bool) {
+	name := req.PathValue("name")
+	records, err := h.db.DumpHistory(req.Context(), name)
+	if err != nil {
+		slog.Error("error getting history", "member", name, "err", err)
+		writeError(w, http.StatusInternalServerError, "error getting history")
+		return nil, false
+	}
+	if len(records) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown member %q", name))
+		return nil, false
+	}
+	return attendance.Days(records, h.db.Loc()), true
+}
+
+// parseTime parses an RFC 3339 time, or a date in the db timezone. Empty
+// strings return the zero time.
+func (h handlers) parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, h.db.Loc()); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func pageSize(req *http.Request) (int, error) {
+	l := req.URL.Query().Get("limit")
+	if l == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(l)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	return limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("error writing response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
@@ -0,0 +1,152 @@
+package httphandlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+func apiGet(t *testing.T, mux http.Handler, path string, v any) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("error decoding response: %s", err)
+	}
+	return resp.Code
+}
+
+func TestApi(t *testing.T) {
+	ctx := context.Background()
+	accessDb := getDb(t, "test_api")
+	defer accessDb.Close()
+
+	day := time.Date(2025, 1, 20, 12, 0, 0, 0, accessDb.Loc())
+	for _, r := range []types.AccessRecord{
+		{Timestamp: day, Name: "Alice", AccessGranted: true},
+		{Timestamp: day.Add(time.Hour), Name: "Bob", AccessGranted: true},
+		{Timestamp: day.AddDate(0, 0, 1), Name: "Alice", AccessGranted: false},
+		{Timestamp: day.AddDate(0, 0, 2), Name: "Alice", AccessGranted: true},
+		{Timestamp: day.AddDate(0, 0, 3), Name: "Carol", AccessGranted: true},
+	} {
+		if _, err := accessDb.AddRecord(ctx, r); err != nil {
+			t.Fatalf("error adding record: %s", err)
+		}
+	}
+	mux := NewMux(accessDb, nil, WithApi())
+
+	t.Run("Members", func(t *testing.T) {
+		var got []string
+		cursor := ""
+		for pages := 0; pages < 3; pages++ {
+			var page membersPage
+			code := apiGet(t, mux, "/api/v1/members?limit=2&cursor="+url.QueryEscape(cursor), &page)
+			if code != http.StatusOK {
+				t.Fatalf("unexpected status %d", code)
+			}
+			for _, s := range page.Members {
+				got = append(got, s.Name)
+			}
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+		want := []string{"Alice", "Bob", "Carol"}
+		if !slices.Equal(got, want) {
+			log.Printf("want: %v", want)
+			log.Printf("got : %v", got)
+			t.Errorf("members differ")
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		var got memberStats
+		if code := apiGet(t, mux, "/api/v1/members/Alice/stats", &got); code != http.StatusOK {
+			t.Fatalf("unexpected status %d", code)
+		}
+		if got.Name != "Alice" || got.Total != 2 || got.TotalBadge.Emoji != ":fatcat:" {
+			t.Errorf("unexpected stats %+v", got)
+		}
+
+		var apiErr apiError
+		if code := apiGet(t, mux, "/api/v1/members/Nobody/stats", &apiErr); code != http.StatusNotFound || apiErr.Error == "" {
+			t.Errorf("unexpected response for unknown member: %d %+v", code, apiErr)
+		}
+	})
+
+	t.Run("History", func(t *testing.T) {
+		var got []types.AccessRecord
+		path := "/api/v1/members/Alice/history?limit=1&from=2025-01-20"
+		for pages := 0; pages < 3; pages++ {
+			var page historyPage
+			if code := apiGet(t, mux, path, &page); code != http.StatusOK {
+				t.Fatalf("unexpected status %d", code)
+			}
+			got = append(got, page.Records...)
+			if page.NextCursor == "" {
+				break
+			}
+			path = "/api/v1/members/Alice/history?limit=1&from=2025-01-20&cursor=" + url.QueryEscape(page.NextCursor)
+		}
+		if len(got) != 3 || got[1].AccessGranted || !got[2].Timestamp.Equal(day.AddDate(0, 0, 2)) {
+			t.Errorf("unexpected history %+v", got)
+		}
+
+		var page historyPage
+		apiGet(t, mux, "/api/v1/members/Alice/history?from=2025-01-21&to=2025-01-22", &page)
+		if len(page.Records) != 1 || page.NextCursor != "" {
+			t.Errorf("unexpected history %+v", page)
+		}
+	})
+
+	t.Run("Attendance", func(t *testing.T) {
+		for _, tt := range []struct {
+			path     string
+			wantCode int
+			wantType string
+			want     string
+		}{
+			{"/api/v1/members/Alice/heatmap.svg?year=2025", http.StatusOK, "image/svg+xml", "<title>2025-01-22: 1 visit</title>"},
+			{"/api/v1/members/Alice/visits.ics", http.StatusOK, "text/calendar; charset=utf-8", "DTSTART;VALUE=DATE:20250122\r\n"},
+			{"/api/v1/members/Alice/heatmap.svg?year=soon", http.StatusBadRequest, "application/json", "invalid year"},
+			{"/api/v1/members/Nobody/visits.ics", http.StatusNotFound, "application/json", "unknown member"},
+		} {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+			if resp.Code != tt.wantCode || resp.Header().Get("Content-Type") != tt.wantType {
+				t.Errorf("unexpected response for %s: %d %q", tt.path, resp.Code, resp.Header().Get("Content-Type"))
+			}
+			if !strings.Contains(resp.Body.String(), tt.want) {
+				t.Errorf("%q not found in the response for %s:\n%s", tt.want, tt.path, resp.Body.String())
+			}
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, path := range []string{
+			"/api/v1/members?limit=0",
+			"/api/v1/members/Alice/history?from=yesterday",
+			"/api/v1/members/Alice/history?cursor=nope",
+			"/api/v1/nothing",
+		} {
+			var apiErr apiError
+			code := apiGet(t, mux, path, &apiErr)
+			if code < 400 || apiErr.Error == "" {
+				t.Errorf("unexpected response for %s: %d %+v", path, code, apiErr)
+			}
+		}
+	})
+}
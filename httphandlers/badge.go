@@ -0,0 +1,102 @@
+package httphandlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+)
+
+const (
+	badgeLabel = "doorbot"
+	// Rough width of a character in 11px Verdana, and the padding around
+	// each half
+	badgeCharWidth = 7
+	badgePadding   = 10
+	// Badges change at most once per arrival, so caches can hold them a bit
+	badgeMaxAge = 300
+)
+
+// Colors for the total tiers, lowest first. Higher tiers use the last one.
+var badgeColors = []string{"#9f9f9f", "#dfb317", "#44cc11", "#007ec6", "#e05d93", "#e05d44", "#333333"}
+
+// memberBadge serves a shields.io style badge with the tier, visit total and
+// streak of a member, for embedding in profiles and the wiki. Only members
+// who opted in get one; everyone else is unknown.
+func (h handlers) memberBadge(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	name, ok := strings.CutSuffix(req.PathValue("file"), ".svg")
+	if !ok || name == "" {
+		http.NotFound(w, req)
+		return
+	}
+
+	shared, err := h.db.BadgeShared(ctx, name)
+	if err != nil {
+		slog.Error("error getting badge opt-in", "member", name, "err", err)
+		http.Error(w, "error getting badge", http.StatusInternalServerError)
+		return
+	}
+	s, err := h.db.Get(ctx, name)
+	if err != nil {
+		slog.Error("error getting stats", "member", name, "err", err)
+		http.Error(w, "error getting badge", http.StatusInternalServerError)
+		return
+	}
+	if !shared || s.Total == 0 {
+		http.Error(w, "Unknown member", http.StatusNotFound)
+		return
+	}
+
+	conf := badges.Current()
+	tier, _ := conf.Total(s.Total)
+	value := fmt.Sprintf("%d %s · %d day streak", s.Total, plural(s.Total, "visit"), s.Streak)
+	if tier.Msg != "" {
+		value = tier.Msg + " · " + value
+	}
+	color := badgeColors[min(max(slices.Index(conf.Totals, tier), 0), len(badgeColors)-1)]
+
+	var buf bytes.Buffer
+	renderBadge(&buf, badgeLabel, value, color)
+	sum := sha256.Sum256(buf.Bytes())
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", badgeMaxAge))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	http.ServeContent(w, req, "", s.Last, bytes.NewReader(buf.Bytes()))
+}
+
+// renderBadge writes a flat badge with a grey label on the left and the value
+// on color on the right
+func renderBadge(buf *bytes.Buffer, label, value, color string) {
+	lw := utf8.RuneCountInString(label)*badgeCharWidth + badgePadding
+	vw := utf8.RuneCountInString(value)*badgeCharWidth + badgePadding
+	label, value = html.EscapeString(label), html.EscapeString(value)
+
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`+"\n", lw+vw, label, value)
+	fmt.Fprintf(buf, "<title>%s: %s</title>\n", label, value)
+	fmt.Fprintf(buf, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`+"\n", lw+vw)
+	buf.WriteString(`<g clip-path="url(#r)">` + "\n")
+	fmt.Fprintf(buf, `<rect width="%d" height="20" fill="#555"/>`+"\n", lw)
+	fmt.Fprintf(buf, `<rect x="%d" width="%d" height="20" fill="%s"/>`+"\n", lw, vw, color)
+	buf.WriteString("</g>\n")
+	buf.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">` + "\n")
+	fmt.Fprintf(buf, `<text x="%d" y="14" textLength="%d">%s</text>`+"\n", lw/2, lw-badgePadding, label)
+	fmt.Fprintf(buf, `<text x="%d" y="14" textLength="%d">%s</text>`+"\n", lw+vw/2, vw-badgePadding, value)
+	buf.WriteString("</g>\n</svg>\n")
+}
+
+func plural(n uint, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
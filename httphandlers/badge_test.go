@@ -0,0 +1,70 @@
+package httphandlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+func TestMemberBadge(t *testing.T) {
+	ctx := context.Background()
+	accessDb := getDb(t, "test_member_badge")
+	defer accessDb.Close()
+
+	day := time.Date(2025, 1, 20, 12, 0, 0, 0, accessDb.Loc())
+	for i := 0; i < 8; i++ {
+		for _, name := range []string{"Johnny", "Private"} {
+			r := types.AccessRecord{Timestamp: day.AddDate(0, 0, i), Name: name, AccessGranted: true}
+			if _, err := accessDb.AddRecord(ctx, r); err != nil {
+				t.Fatalf("error adding record: %s", err)
+			}
+		}
+	}
+	if err := accessDb.SetBadgeShared(ctx, "Johnny", true); err != nil {
+		t.Fatalf("error sharing badge: %s", err)
+	}
+	mux := NewMux(accessDb, nil, WithBadges())
+
+	for _, tt := range []struct {
+		name     string
+		path     string
+		wantCode int
+		want     string
+	}{
+		{"Shared", "/badge/Johnny.svg", http.StatusOK, "<title>doorbot: UNO · 8 visits · 8 day streak</title>"},
+		{"Not shared", "/badge/Private.svg", http.StatusNotFound, ""},
+		{"Unknown", "/badge/Nobody.svg", http.StatusNotFound, ""},
+		{"Not an SVG", "/badge/Johnny", http.StatusNotFound, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if resp.Code != tt.wantCode {
+				t.Fatalf("unexpected status %d, want %d", resp.Code, tt.wantCode)
+			}
+			if !strings.Contains(resp.Body.String(), tt.want) {
+				t.Errorf("%q not found in:\n%s", tt.want, resp.Body)
+			}
+		})
+	}
+
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/badge/Johnny.svg", nil))
+	etag := resp.Header().Get("ETag")
+	if resp.Header().Get("Content-Type") != "image/svg+xml" || resp.Header().Get("Cache-Control") == "" || etag == "" {
+		t.Errorf("unexpected headers %v", resp.Header())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/badge/Johnny.svg", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotModified {
+		t.Errorf("unexpected status %d for a cached badge", resp.Code)
+	}
+}
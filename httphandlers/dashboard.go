@@ -0,0 +1,311 @@
+package httphandlers
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/sender"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	// Months shown in member calendars, including the current one
+	calendarMonths = 3
+	// Enough to cover calendarMonths of visits, even with several a day
+	calendarRecords = 1000
+	// How long leaderboards are reused, so page loads and kiosk screens don't
+	// replay the whole history every time
+	leaderboardTtl = time.Minute
+)
+
+var (
+	//go:embed dashboard
+	dashboardFiles embed.FS
+
+	dashboardTemplates = template.Must(
+		template.New("dashboard").
+			Funcs(template.FuncMap{"memberUrl": memberUrl}).
+			ParseFS(dashboardFiles, "dashboard/*.html.tmpl"),
+	)
+)
+
+type dashboardPage struct {
+	Title string
+	Now   time.Time
+}
+
+type indexPage struct {
+	dashboardPage
+	Here         []arrivalRow
+	Members      int
+	Leaderboards []leaderboardView
+}
+
+type arrivalRow struct {
+	Stats      types.Stats
+	Arrived    time.Time
+	TotalBadge badges.Tier
+}
+
+type leaderboardView struct {
+	Title string
+	sender.Leaderboard
+}
+
+// leaderboardCache keeps the last leaderboard of each period
+type leaderboardCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedLeaderboard
+}
+
+type cachedLeaderboard struct {
+	builtAt     time.Time
+	leaderboard sender.Leaderboard
+}
+
+func newLeaderboardCache() *leaderboardCache {
+	return &leaderboardCache{entries: make(map[string]cachedLeaderboard)}
+}
+
+type memberPage struct {
+	dashboardPage
+	Stats        types.Stats
+	TotalBadge   badges.Tier
+	StreakBadge  badges.Tier
+	Calendar     []calendarMonth
+	Achievements []types.Achievement
+}
+
+type calendarMonth struct {
+	Title string
+	// Weeks start on Monday. Days outside the month are zero.
+	Weeks [][7]calendarDay
+}
+
+type calendarDay struct {
+	Day     int
+	Visited bool
+	Today   bool
+}
+
+func (h handlers) handleDashboard(mux *http.ServeMux) {
+	mux.HandleFunc("GET /dashboard", h.read(h.dashboardIndex))
+	mux.HandleFunc("GET /dashboard/members/{name}", h.read(h.dashboardMember))
+}
+
+// handleStatic serves the stylesheets and scripts of the dashboard and kiosk
+func handleStatic(mux *http.ServeMux) {
+	static, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+
+	files := http.StripPrefix("/dashboard/static", http.FileServerFS(static))
+	for _, f := range []string{"style.css", "kiosk.css", "kiosk.js"} {
+		mux.Handle("GET /dashboard/static/"+f, files)
+	}
+}
+
+func (h handlers) dashboardIndex(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	now := time.Now().In(h.db.Loc())
+	page := indexPage{dashboardPage: dashboardPage{Title: "Today at the lab", Now: now}}
+
+	here, err := h.here(ctx)
+	if err != nil {
+		h.dashboardError(w, "error getting today's arrivals", err)
+		return
+	}
+	conf := badges.Current()
+	for _, r := range here {
+		s, err := h.db.Get(ctx, r.Name)
+		if err != nil {
+			h.dashboardError(w, "error getting stats", err)
+			return
+		}
+		tier, _ := conf.Total(s.Total)
+		page.Here = append(page.Here, arrivalRow{Stats: s, Arrived: r.Timestamp.In(h.db.Loc()), TotalBadge: tier})
+	}
+
+	members, err := h.db.Members(ctx)
+	if err != nil {
+		h.dashboardError(w, "error listing members", err)
+		return
+	}
+	page.Members = len(members)
+
+	for _, board := range []struct{ period, title string }{
+		{sender.DigestWeekly, "This week"},
+		{sender.DigestMonthly, "This month"},
+	} {
+		l, err := h.leaderboard(ctx, board.period, now)
+		if err != nil {
+			h.dashboardError(w, "error building the leaderboard", err)
+			return
+		}
+		page.Leaderboards = append(page.Leaderboards, leaderboardView{Title: board.title, Leaderboard: l})
+	}
+
+	h.renderDashboard(w, "index.html.tmpl", page)
+}
+
+// here returns who came in today, leaving out members who don't want their
+// arrivals announced
+func (h handlers) here(ctx context.Context) ([]types.AccessRecord, error) {
+	here, err := h.db.Here(ctx)
+	if err != nil {
+		return nil, err
+	}
+	optedOut, err := h.db.OptedOut(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting preferences: %w", err)
+	}
+	return slices.DeleteFunc(here, func(r types.AccessRecord) bool {
+		return optedOut[r.Name]
+	}), nil
+}
+
+// leaderboard returns the leaderboard of the period containing now so far,
+// built at most leaderboardTtl ago
+func (h handlers) leaderboard(ctx context.Context, period string, now time.Time) (sender.Leaderboard, error) {
+	from, to, err := sender.LeaderboardRange(period, now, true)
+	if err != nil {
+		return sender.Leaderboard{}, err
+	}
+
+	c := h.leaderboards
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[period]; ok && e.leaderboard.From.Equal(from) && now.Sub(e.builtAt) < leaderboardTtl {
+		return e.leaderboard, nil
+	}
+
+	l, err := sender.BuildLeaderboard(ctx, h.db, period, from, to)
+	if err != nil {
+		return sender.Leaderboard{}, err
+	}
+	c.entries[period] = cachedLeaderboard{builtAt: now, leaderboard: l}
+	return l, nil
+}
+
+func (h handlers) dashboardMember(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	name := req.PathValue("name")
+	// Members who don't want their arrivals announced don't get a page
+	optedOut, err := h.db.OptedOut(ctx)
+	if err != nil {
+		h.dashboardError(w, "error getting preferences", err)
+		return
+	}
+	if optedOut[name] {
+		http.Error(w, "Unknown member", http.StatusNotFound)
+		return
+	}
+
+	s, err := h.db.Get(ctx, name)
+	if err != nil {
+		h.dashboardError(w, "error getting stats", err)
+		return
+	}
+	if s.Total == 0 {
+		http.Error(w, "Unknown member", http.StatusNotFound)
+		return
+	}
+	s.Last = s.Last.In(h.db.Loc())
+
+	now := time.Now().In(h.db.Loc())
+	first := time.Date(now.Year(), now.Month()-calendarMonths+1, 1, 0, 0, 0, 0, h.db.Loc())
+	records, err := h.db.MemberHistory(ctx, name, first, time.Time{}, calendarRecords)
+	if err != nil {
+		h.dashboardError(w, "error getting history", err)
+		return
+	}
+
+	awards, err := h.db.Achievements(ctx, name)
+	if err != nil {
+		h.dashboardError(w, "error getting achievements", err)
+		return
+	}
+
+	conf := badges.Current()
+	tBadge, _ := conf.Total(s.Total)
+	sBadge, _ := conf.Streak(s.Streak)
+	h.renderDashboard(w, "member.html.tmpl", memberPage{
+		dashboardPage: dashboardPage{Title: name, Now: now},
+		Stats:         s,
+		TotalBadge:    tBadge,
+		StreakBadge:   sBadge,
+		Calendar:      calendar(records, now, calendarMonths),
+		Achievements:  awards,
+	})
+}
+
+// calendar lays out the last months up to now, marking the days with granted
+// access records
+func calendar(records []types.AccessRecord, now time.Time, months int) []calendarMonth {
+	visited := make(map[time.Time]bool)
+	for _, r := range records {
+		if r.AccessGranted {
+			y, m, d := r.Timestamp.In(now.Location()).Date()
+			visited[time.Date(y, m, d, 0, 0, 0, 0, now.Location())] = true
+		}
+	}
+
+	var result []calendarMonth
+	y, m, _ := now.Date()
+	today := time.Date(y, m, now.Day(), 0, 0, 0, 0, now.Location())
+	for i := months - 1; i >= 0; i-- {
+		first := time.Date(y, m-time.Month(i), 1, 0, 0, 0, 0, now.Location())
+		month := calendarMonth{Title: first.Format("January 2006")}
+
+		var week [7]calendarDay
+		for d := first; d.Month() == first.Month(); d = d.AddDate(0, 0, 1) {
+			// Monday is 0
+			wd := (int(d.Weekday()) + 6) % 7
+			week[wd] = calendarDay{Day: d.Day(), Visited: visited[d], Today: d.Equal(today)}
+			if wd == 6 {
+				month.Weeks = append(month.Weeks, week)
+				week = [7]calendarDay{}
+			}
+		}
+		if week != [7]calendarDay{} {
+			month.Weeks = append(month.Weeks, week)
+		}
+		result = append(result, month)
+	}
+	return result
+}
+
+// renderDashboard renders to a buffer first, so errors don't leave a half
+// written page behind
+func (h handlers) renderDashboard(w http.ResponseWriter, name string, data any) {
+	var buf bytes.Buffer
+	if err := dashboardTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		h.dashboardError(w, "error rendering the dashboard", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := buf.WriteTo(w); err != nil {
+		slog.Error("error writing dashboard", "err", err)
+	}
+}
+
+func (h handlers) dashboardError(w http.ResponseWriter, msg string, err error) {
+	slog.Error(msg, "err", err)
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+func memberUrl(name string) string {
+	return "/dashboard/members/" + url.PathEscape(name)
+}
@@ -0,0 +1,73 @@
+// Shows a welcome for every arrival streamed on /events, and the idle view
+// in between
+(function () {
+  const body = document.body;
+  const doors = JSON.parse(body.dataset.doors);
+  const seconds = Number(body.dataset.seconds);
+  const here = new Set(JSON.parse(body.dataset.here));
+
+  // Slack shortcodes of the built-in badges with a Unicode equivalent.
+  // Custom ones are left out.
+  const emoji = {
+    ":cat2:": "🐈",
+    ":black_cat:": "🐈‍⬛",
+    ":rat:": "🐀",
+    ":tiger2:": "🐅",
+    ":leopard:": "🐆",
+    ":house_with_garden:": "🏡",
+  };
+
+  const idle = document.getElementById("idle");
+  const welcome = document.getElementById("welcome");
+  const queue = [];
+  let showing = false;
+
+  function show() {
+    if (showing || queue.length === 0) {
+      return;
+    }
+    const a = queue.shift();
+    showing = true;
+    document.getElementById("name").textContent = a.stats.name;
+    document.getElementById("emoji").textContent =
+      emoji[a.streak_badge.emoji] || emoji[a.total_badge.emoji] || "👋";
+    document.getElementById("total").textContent = a.stats.total;
+    document.getElementById("streak").textContent = a.stats.streak;
+    document.getElementById("medal").textContent = a.total_badge.msg;
+    idle.hidden = true;
+    welcome.hidden = false;
+    setTimeout(function () {
+      welcome.hidden = true;
+      idle.hidden = false;
+      showing = false;
+      show();
+    }, seconds * 1000);
+  }
+
+  // EventSource can't set headers, so the token the page was opened with, if
+  // any, is passed along in the query
+  const token = new URLSearchParams(location.search).get("access_token");
+  const source = new EventSource(
+    token ? "/events?access_token=" + encodeURIComponent(token) : "/events",
+  );
+  source.addEventListener("arrival", function (e) {
+    const a = JSON.parse(e.data);
+    // Everyone counts, whatever the door
+    if (!here.has(a.stats.name)) {
+      here.add(a.stats.name);
+      document.getElementById("count").textContent = here.size;
+    }
+    if (doors.length > 0 && !doors.includes(a.door)) {
+      return;
+    }
+    queue.push(a);
+    show();
+  });
+
+  // Picks up the latest leaderboard, and a new day, between welcomes
+  setInterval(function () {
+    if (!showing) {
+      location.reload();
+    }
+  }, 5 * 60 * 1000);
+})();
@@ -0,0 +1,145 @@
+package httphandlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/sender"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+func TestDashboard(t *testing.T) {
+	ctx := context.Background()
+	accessDb := getDb(t, "test_dashboard")
+	defer accessDb.Close()
+
+	now := time.Now().In(accessDb.Loc())
+	for _, r := range []types.AccessRecord{
+		{Timestamp: now.AddDate(0, 0, -1), Name: "Johnny Melavo", AccessGranted: true},
+		{Timestamp: now, Name: "Johnny Melavo", AccessGranted: true},
+		{Timestamp: now.AddDate(0, 0, -3), Name: "Absent <b>", AccessGranted: true},
+		{Timestamp: now, Name: "Shy", AccessGranted: true},
+	} {
+		if _, err := accessDb.AddRecord(ctx, r); err != nil {
+			t.Fatalf("error adding record: %s", err)
+		}
+	}
+	if err := accessDb.SetAnnounce(ctx, "Shy", types.AnnounceNever); err != nil {
+		t.Fatalf("error setting preferences: %s", err)
+	}
+	mux := NewMux(accessDb, nil, WithDashboard())
+
+	for _, tt := range []struct {
+		name     string
+		path     string
+		wantCode int
+		want     []string
+		dontWant []string
+	}{
+		{
+			name:     "Index",
+			path:     "/dashboard",
+			wantCode: http.StatusOK,
+			want: []string{
+				`<div class="big">1</div>came in today`,
+				`<a href="/dashboard/members/Johnny%20Melavo">Johnny Melavo</a>`,
+				"Absent &lt;b&gt;",
+			},
+			// Neither listed, in the leaderboards either, nor linked
+			dontWant: []string{"Shy", memberUrl("Shy")},
+		},
+		{
+			name:     "Member",
+			path:     "/dashboard/members/Johnny%20Melavo",
+			wantCode: http.StatusOK,
+			want:     []string{"<h1>Johnny Melavo</h1>", `<td class="visited today">`, now.Format("January 2006")},
+		},
+		{name: "Unknown member", path: "/dashboard/members/Nobody", wantCode: http.StatusNotFound},
+		{name: "Opted-out member", path: "/dashboard/members/Shy", wantCode: http.StatusNotFound},
+		{name: "Stylesheet", path: "/dashboard/static/style.css", wantCode: http.StatusOK},
+		{name: "Templates aren't served", path: "/dashboard/static/index.html.tmpl", wantCode: http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+			if resp.Code != tt.wantCode {
+				t.Fatalf("unexpected status %d, want %d", resp.Code, tt.wantCode)
+			}
+			body := resp.Body.String()
+			for _, w := range tt.want {
+				if !strings.Contains(body, w) {
+					t.Errorf("%q not found in:\n%s", w, body)
+				}
+			}
+			for _, w := range tt.dontWant {
+				if strings.Contains(body, w) {
+					t.Errorf("%q found in:\n%s", w, body)
+				}
+			}
+		})
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	loc := time.UTC
+	now := time.Date(2025, 3, 12, 18, 0, 0, 0, loc)
+	got := calendar([]types.AccessRecord{
+		{Timestamp: time.Date(2025, 1, 31, 9, 0, 0, 0, loc), AccessGranted: true},
+		{Timestamp: time.Date(2025, 2, 1, 9, 0, 0, 0, loc), AccessGranted: false},
+		{Timestamp: time.Date(2025, 3, 12, 9, 0, 0, 0, loc), AccessGranted: true},
+	}, now, 3)
+
+	if len(got) != 3 || got[0].Title != "January 2025" || got[2].Title != "March 2025" {
+		t.Fatalf("unexpected months %+v", got)
+	}
+	// January 1st 2025 was a Wednesday, and the 31st a Friday
+	if jan := got[0].Weeks; jan[0][2].Day != 1 || jan[0][1].Day != 0 || !jan[4][4].Visited || jan[4][4].Day != 31 {
+		t.Errorf("unexpected January %+v", jan)
+	}
+	// February 2025 starts on a Saturday and fits in 5 weeks
+	if feb := got[1].Weeks; len(feb) != 5 || feb[0][5].Day != 1 || feb[0][5].Visited {
+		t.Errorf("unexpected February %+v", feb)
+	}
+	if d := got[2].Weeks[2][2]; d.Day != 12 || !d.Visited || !d.Today {
+		t.Errorf("unexpected March 12th %+v", d)
+	}
+}
+
+func TestLeaderboardCache(t *testing.T) {
+	ctx := context.Background()
+	accessDb := getDb(t, "test_leaderboard_cache")
+	defer accessDb.Close()
+	h := handlers{db: accessDb, leaderboards: newLeaderboardCache()}
+
+	now := time.Date(2025, 3, 5, 12, 0, 0, 0, accessDb.Loc())
+	add := func(name string) {
+		r := types.AccessRecord{Timestamp: now.Add(-time.Hour), Name: name, AccessGranted: true}
+		if _, err := accessDb.AddRecord(ctx, r); err != nil {
+			t.Fatalf("error adding record: %s", err)
+		}
+	}
+	visitors := func(at time.Time) int {
+		l, err := h.leaderboard(ctx, sender.DigestWeekly, at)
+		if err != nil {
+			t.Fatalf("error building leaderboard: %s", err)
+		}
+		return len(l.Visits)
+	}
+
+	add("A")
+	if n := visitors(now); n != 1 {
+		t.Errorf("unexpected visitors %d, want 1", n)
+	}
+	add("B")
+	if n := visitors(now.Add(time.Second)); n != 1 {
+		t.Errorf("leaderboard wasn't reused, got %d visitors", n)
+	}
+	if n := visitors(now.Add(leaderboardTtl)); n != 2 {
+		t.Errorf("leaderboard wasn't rebuilt, got %d visitors", n)
+	}
+}
@@ -0,0 +1,179 @@
+package httphandlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/events"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	arrivalEvent = "arrival"
+	// How long clients wait before reconnecting
+	eventsRetry = 5 * time.Second
+	// Most arrivals, and how far back, looked up in the history for clients
+	// resuming from before the recent events
+	maxReplayed  = 100
+	replayWindow = 24 * time.Hour
+)
+
+// How often a comment is sent to idle streams, so proxies and clients don't
+// give up on them
+var heartbeatInterval = 15 * time.Second
+
+// eventPayload is the data of arrival events
+type eventPayload struct {
+	types.Arrival
+	TotalBadge  badges.Tier `json:"total_badge"`
+	StreakBadge badges.Tier `json:"streak_badge"`
+}
+
+// WithEvents streams the arrivals published to b as Server-Sent Events on
+// /events, and publishes every stored arrival to it
+func WithEvents(b *events.Broker) Option {
+	return func(h *handlers) {
+		h.events = b
+	}
+}
+
+func (h handlers) eventStream(w http.ResponseWriter, req *http.Request) {
+	rc := http.NewResponseController(w)
+
+	var lastId uint64
+	if id := req.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		if lastId, err = strconv.ParseUint(id, 10, 64); err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	missed, complete, ch, cancel, err := h.events.Subscribe(lastId)
+	if errors.Is(err, events.ErrTooManySubscribers) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(eventsRetry.Seconds())))
+		http.Error(w, "Too many subscribers", http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, events.ErrClosed) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(eventsRetry.Seconds())))
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		slog.Error("error subscribing to events", "err", err)
+		http.Error(w, "Error subscribing to events", http.StatusInternalServerError)
+		return
+	}
+	defer cancel()
+
+	if !complete {
+		replayed, err := h.replay(req.Context(), lastId, missed)
+		if err != nil {
+			slog.Error("error replaying events", "err", err)
+			http.Error(w, "Error replaying events", http.StatusInternalServerError)
+			return
+		}
+		missed = append(replayed, missed...)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+	for _, e := range missed {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		slog.Error("error flushing events", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				// Fell behind. The client reconnects and resumes from the
+				// last event it got.
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// replay looks up the arrivals after lastId, which the broker no longer has,
+// in the history. Ids are when events were published, so arrivals stored
+// after then are replayed, up to the first of kept. Replayed arrivals carry
+// the current stats of members.
+func (h handlers) replay(ctx context.Context, lastId uint64, kept []events.Event) ([]events.Event, error) {
+	to := time.Now()
+	if len(kept) > 0 {
+		to = kept[0].Arrival.Record.Timestamp
+	}
+	from := time.Unix(0, int64(lastId+1))
+	if earliest := to.Add(-replayWindow); from.Before(earliest) {
+		from = earliest
+	}
+	records, err := h.db.History(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	records = slices.DeleteFunc(records, func(r types.AccessRecord) bool {
+		return !r.AccessGranted || r.Name == ""
+	})
+	records = records[max(0, len(records)-maxReplayed):]
+
+	var result []events.Event
+	stats := make(map[string]types.Stats)
+	for _, r := range records {
+		s, ok := stats[r.Name]
+		if !ok {
+			if s, err = h.db.Get(ctx, r.Name); err != nil {
+				return nil, err
+			}
+			stats[r.Name] = s
+		}
+		a := types.Arrival{Record: r, Stats: s}
+		if h.announce(ctx, a) {
+			result = append(result, events.Event{Id: uint64(r.Timestamp.UnixNano()), Arrival: a})
+		}
+	}
+	return result, nil
+}
+
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+	conf := badges.Current()
+	tBadge, _ := conf.Total(e.Arrival.Stats.Total)
+	sBadge, _ := conf.Streak(e.Arrival.Stats.Streak)
+	data, err := json.Marshal(eventPayload{Arrival: e.Arrival, TotalBadge: tBadge, StreakBadge: sBadge})
+	if err != nil {
+		slog.Error("error encoding event", "err", err)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, arrivalEvent, data)
+	return err
+}
@@ -0,0 +1,277 @@
+package httphandlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/events"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+type sseEvent struct {
+	id, event, data, comment string
+}
+
+// readEvents sends the events read from an SSE stream to the returned channel
+func readEvents(t *testing.T, url, lastId string) (<-chan sseEvent, *http.Response) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	if lastId != "" {
+		req.Header.Set("Last-Event-ID", lastId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+
+	ch := make(chan sseEvent)
+	go func() {
+		defer close(ch)
+		var e sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if e != (sseEvent{}) {
+					ch <- e
+				}
+				e = sseEvent{}
+			case strings.HasPrefix(line, ":"):
+				e.comment = strings.TrimSpace(line[1:])
+			default:
+				k, v, _ := strings.Cut(line, ": ")
+				switch k {
+				case "id":
+					e.id = v
+				case "event":
+					e.event = v
+				case "data":
+					e.data = v
+				}
+			}
+		}
+	}()
+	return ch, resp
+}
+
+func nextEvent(t *testing.T, ch <-chan sseEvent) sseEvent {
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				t.Fatalf("stream closed")
+			}
+			// Skip the retry setting
+			if e.id == "" && e.comment == "" {
+				continue
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for an event")
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	accessDb := getDb(t, "test_events")
+	defer accessDb.Close()
+
+	defer func(d time.Duration) { heartbeatInterval = d }(heartbeatInterval)
+	heartbeatInterval = 50 * time.Millisecond
+
+	mux := NewMux(accessDb, nil, WithEvents(events.NewBroker(1, 10)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	stream, resp := readEvents(t, srv.URL+"/events", "")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	// Only one subscriber is allowed
+	over, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	over.Body.Close()
+	if over.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status over the subscriber limit: %d", over.StatusCode)
+	}
+
+	if e := nextEvent(t, stream); e.comment != "heartbeat" {
+		t.Errorf("expected a heartbeat, got %+v", e)
+	}
+
+	ts := time.Date(2025, 1, 20, 12, 0, 0, 0, accessDb.Loc())
+	var ids []string
+	for _, name := range []string{"Alice", "Bob"} {
+		req := udmReqBuilderFromMsg(udmMsg{
+			Data: udmMsgData{
+				Actor:  &udmActor{Name: name},
+				Object: &udmObject{Result: granted},
+			},
+			TimeForTesting: &ts,
+		})(t)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+
+		e := nextEvent(t, stream)
+		for e.comment != "" {
+			e = nextEvent(t, stream)
+		}
+		var got eventPayload
+		if err := json.Unmarshal([]byte(e.data), &got); err != nil {
+			t.Fatalf("error decoding event %+v: %s", e, err)
+		}
+		if e.event != arrivalEvent || got.Stats.Name != name || got.Stats.Total != 1 || got.TotalBadge.Emoji != ":fatcat:" {
+			t.Errorf("unexpected event %+v", e)
+		}
+		ids = append(ids, e.id)
+	}
+	resp.Body.Close()
+
+	// Reconnecting resumes after the last event seen
+	var resumed sseEvent
+	for i := 0; i < 50; i++ {
+		stream, resp = readEvents(t, srv.URL+"/events", ids[0])
+		if resp.StatusCode == http.StatusOK {
+			resumed = nextEvent(t, stream)
+			resp.Body.Close()
+			break
+		}
+		// Until the previous subscription is gone
+		resp.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	var got types.Arrival
+	json.Unmarshal([]byte(resumed.data), &got)
+	if resumed.id != ids[1] || got.Stats.Name != "Bob" {
+		t.Errorf("unexpected resumed event %+v", resumed)
+	}
+}
+
+func TestEventsReplay(t *testing.T) {
+	ctx := context.Background()
+	accessDb := getDb(t, "test_events_replay")
+	defer accessDb.Close()
+
+	defer func(d time.Duration) { heartbeatInterval = d }(heartbeatInterval)
+	heartbeatInterval = 50 * time.Millisecond
+
+	// Whole seconds, so MySQL doesn't round them
+	now := time.Now().In(accessDb.Loc()).Truncate(time.Second)
+	for _, r := range []types.AccessRecord{
+		{Timestamp: now.Add(-2 * time.Hour), Name: "Alice", AccessGranted: true},
+		{Timestamp: now.Add(-time.Hour), Name: "Bob", AccessGranted: true},
+		{Timestamp: now.Add(-time.Hour + time.Minute), Name: "Shy", AccessGranted: true},
+		{Timestamp: now.Add(-time.Hour + 2*time.Minute), Name: "Carol", AccessGranted: false},
+	} {
+		if _, err := accessDb.AddRecord(ctx, r); err != nil {
+			t.Fatalf("error adding record: %s", err)
+		}
+	}
+	if err := accessDb.SetAnnounce(ctx, "Shy", types.AnnounceNever); err != nil {
+		t.Fatalf("error setting preferences: %s", err)
+	}
+
+	// A client resuming after a restart gets what it missed from the history
+	srv := httptest.NewServer(NewMux(accessDb, nil, WithEvents(events.NewBroker(1, 10))))
+	defer srv.Close()
+	lastId := strconv.FormatInt(now.Add(-90*time.Minute).UnixNano(), 10)
+	stream, resp := readEvents(t, srv.URL+"/events", lastId)
+	defer resp.Body.Close()
+
+	e := nextEvent(t, stream)
+	var got types.Arrival
+	json.Unmarshal([]byte(e.data), &got)
+	if want := strconv.FormatInt(now.Add(-time.Hour).UnixNano(), 10); e.id != want || got.Stats.Name != "Bob" {
+		t.Errorf("unexpected replayed event %+v", e)
+	}
+	if e := nextEvent(t, stream); e.comment != "heartbeat" {
+		t.Errorf("expected a heartbeat, got %+v", e)
+	}
+}
+
+func TestKiosk(t *testing.T) {
+	accessDb := getDb(t, "test_kiosk")
+	defer accessDb.Close()
+
+	// Whole seconds, so MySQL doesn't round it into the future
+	now := time.Now().In(accessDb.Loc()).Truncate(time.Second)
+	for _, r := range []types.AccessRecord{
+		{Timestamp: now, Name: `Johnny "JM" Melavo`, AccessGranted: true},
+		{Timestamp: now, Name: "Shy", AccessGranted: true},
+	} {
+		if _, err := accessDb.AddRecord(context.Background(), r); err != nil {
+			t.Fatalf("error adding record: %s", err)
+		}
+	}
+	if err := accessDb.SetAnnounce(context.Background(), "Shy", types.AnnounceNever); err != nil {
+		t.Fatalf("error setting preferences: %s", err)
+	}
+	mux := NewMux(accessDb, nil, WithEvents(events.NewBroker(1, 10)))
+
+	for _, tt := range []struct {
+		name     string
+		query    string
+		wantCode int
+		want     []string
+	}{
+		{
+			name:     "Defaults",
+			wantCode: http.StatusOK,
+			want: []string{
+				`data-doors="[]"`,
+				`data-seconds="8"`,
+				`data-here="[&#34;Johnny \&#34;JM\&#34; Melavo&#34;]"`,
+				`<span id="count">1</span>`,
+				"<h2>This week</h2>",
+				`<li>Johnny &#34;JM&#34; Melavo <span class="value">1</span></li>`,
+			},
+		},
+		{
+			name:     "Configured",
+			query:    "?door=Front+Door&door=Back+Door&seconds=5&period=month&title=Hi",
+			wantCode: http.StatusOK,
+			want: []string{
+				`data-doors="[&#34;Front Door&#34;,&#34;Back Door&#34;]"`,
+				`data-seconds="5"`,
+				"<h1>Hi</h1>",
+				"<h2>This month</h2>",
+			},
+		},
+		{name: "Invalid seconds", query: "?seconds=0", wantCode: http.StatusBadRequest},
+		{name: "Invalid period", query: "?period=year", wantCode: http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/kiosk"+tt.query, nil)
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, req)
+			if resp.Code != tt.wantCode {
+				t.Fatalf("unexpected status %d, want %d", resp.Code, tt.wantCode)
+			}
+			body := resp.Body.String()
+			for _, w := range tt.want {
+				if !strings.Contains(body, w) {
+					t.Errorf("%q not found in:\n%s", w, body)
+				}
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/dashboard/static/kiosk.js", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Errorf("unexpected status %d for the script", resp.Code)
+	}
+}
@@ -0,0 +1,105 @@
+package httphandlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/version"
+)
+
+const (
+	statusOk       = "ok"
+	statusDegraded = "degraded"
+	statusError    = "error"
+
+	// How long each readiness check gets
+	checkTimeout = 3 * time.Second
+)
+
+var started = time.Now()
+
+type readinessCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+type healthStatus struct {
+	Status  string                 `json:"status"`
+	Version string                 `json:"version,omitempty"`
+	Uptime  int64                  `json:"uptime_seconds"`
+	Checks  map[string]checkResult `json:"checks,omitempty"`
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// How long the check took, in milliseconds
+	Duration int64 `json:"duration_ms"`
+}
+
+// WithReadinessCheck adds a check to /readyz, on top of the database ones
+func WithReadinessCheck(name string, check func(context.Context) error) Option {
+	return func(h *handlers) {
+		h.checks = append(h.checks, readinessCheck{name: name, check: check})
+	}
+}
+
+// healthz reports the process is up and serving requests
+func (h handlers) healthz(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, newHealthStatus())
+}
+
+// readyz runs every readiness check at once, and fails with a 503 if any
+// does
+func (h handlers) readyz(w http.ResponseWriter, req *http.Request) {
+	checks := append([]readinessCheck{
+		{name: "db", check: h.db.Ping},
+		{name: "schema", check: h.db.CheckSchema},
+	}, h.checks...)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	status := newHealthStatus()
+	status.Checks = make(map[string]checkResult, len(checks))
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			result := checkResult{Status: statusOk}
+			if err := c.check(ctx); err != nil {
+				slog.Warn("readiness check failed", "check", c.name, "err", err)
+				result = checkResult{Status: statusError, Error: err.Error()}
+			}
+			result.Duration = time.Since(start).Milliseconds()
+
+			mu.Lock()
+			defer mu.Unlock()
+			status.Checks[c.name] = result
+			if result.Status != statusOk {
+				status.Status = statusDegraded
+			}
+		}()
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	if status.Status != statusOk {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
+
+func newHealthStatus() healthStatus {
+	return healthStatus{
+		Status:  statusOk,
+		Version: version.Version,
+		Uptime:  int64(time.Since(started).Seconds()),
+	}
+}
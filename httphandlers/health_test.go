@@ -0,0 +1,59 @@
+package httphandlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	accessDb := getDb(t, "test_health")
+	defer accessDb.Close()
+
+	failing := func(context.Context) error { return errors.New("invalid_auth") }
+	for _, tt := range []struct {
+		name       string
+		path       string
+		opts       []Option
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "Alive",
+			path:       "/healthz",
+			wantCode:   http.StatusOK,
+			wantStatus: statusOk,
+		},
+		{
+			name:       "Ready",
+			path:       "/readyz",
+			opts:       []Option{WithReadinessCheck("slack", func(context.Context) error { return nil })},
+			wantCode:   http.StatusOK,
+			wantStatus: statusOk,
+			wantChecks: map[string]string{"db": statusOk, "schema": statusOk, "slack": statusOk},
+		},
+		{
+			name:       "Degraded",
+			path:       "/readyz",
+			opts:       []Option{WithReadinessCheck("slack", failing)},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: statusDegraded,
+			wantChecks: map[string]string{"db": statusOk, "schema": statusOk, "slack": statusError},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got healthStatus
+			code := apiGet(t, NewMux(accessDb, nil, tt.opts...), tt.path, &got)
+			if code != tt.wantCode || got.Status != tt.wantStatus {
+				t.Errorf("unexpected response %d %+v", code, got)
+			}
+			for name, want := range tt.wantChecks {
+				if got.Checks[name].Status != want {
+					t.Errorf("unexpected %s check %+v, want %s", name, got.Checks[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/sender"
+)
+
+const (
+	defaultKioskSeconds = 8
+	maxKioskSeconds     = 60
+)
+
+type kioskPage struct {
+	Title string
+	// JSON lists for the script
+	Doors string
+	Here  string
+	// How long each welcome is shown for
+	Seconds     int
+	Count       int
+	Leaderboard leaderboardView
+}
+
+// kiosk serves a full-screen page welcoming arrivals as they come, for
+// screens by the doors. The query can set:
+//   - door: only welcome arrivals through this door. Can be repeated.
+//   - seconds: how long each welcome is shown for
+//   - period: "week" or "month", for the leaderboard shown in between
+//   - title: heading shown in between
+func (h handlers) kiosk(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	q := req.URL.Query()
+
+	page := kioskPage{Title: q.Get("title"), Seconds: defaultKioskSeconds}
+	if page.Title == "" {
+		page.Title = "Welcome to the lab"
+	}
+	if s := q.Get("seconds"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxKioskSeconds {
+			http.Error(w, "seconds must be between 1 and "+strconv.Itoa(maxKioskSeconds), http.StatusBadRequest)
+			return
+		}
+		page.Seconds = n
+	}
+	period := q.Get("period")
+	switch period {
+	case "", sender.DigestWeekly:
+		period, page.Leaderboard.Title = sender.DigestWeekly, "This week"
+	case sender.DigestMonthly:
+		page.Leaderboard.Title = "This month"
+	default:
+		http.Error(w, `period must be "week" or "month"`, http.StatusBadRequest)
+		return
+	}
+
+	doors := q["door"]
+	if doors == nil {
+		doors = []string{}
+	}
+	b, err := json.Marshal(doors)
+	if err != nil {
+		h.dashboardError(w, "error encoding doors", err)
+		return
+	}
+	page.Doors = string(b)
+
+	here, err := h.here(ctx)
+	if err != nil {
+		h.dashboardError(w, "error getting today's arrivals", err)
+		return
+	}
+	names := make([]string, 0, len(here))
+	for _, r := range here {
+		names = append(names, r.Name)
+	}
+	if b, err = json.Marshal(names); err != nil {
+		h.dashboardError(w, "error encoding arrivals", err)
+		return
+	}
+	page.Here, page.Count = string(b), len(names)
+
+	now := time.Now().In(h.db.Loc())
+	if page.Leaderboard.Leaderboard, err = h.leaderboard(ctx, period, now); err != nil {
+		h.dashboardError(w, "error building the leaderboard", err)
+		return
+	}
+
+	h.renderDashboard(w, "kiosk.html.tmpl", page)
+}
@@ -4,17 +4,104 @@ import (
 	"net/http"
 
 	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/events"
+	"github.com/fatcatfablab/doorbot2/metrics"
 	"github.com/fatcatfablab/doorbot2/types"
 )
 
 type handlers struct {
-	db    *db.DB
-	slack types.Sender
+	db          *db.DB
+	sender      types.Sender
+	slackSecret string
+	api         bool
+	tokens      bool
+	dashboard   bool
+	badges      bool
+	metrics     bool
+	checks      []readinessCheck
+	events      *events.Broker
+	// Shared by the dashboard and kiosk
+	leaderboards *leaderboardCache
 }
 
-func NewMux(accessDb *db.DB, slack types.Sender) *http.ServeMux {
-	h := handlers{db: accessDb, slack: slack}
+type Option func(*handlers)
+
+// WithSlackCommands enables the Slack slash command endpoint. Requests are
+// verified with the Slack app signing secret.
+func WithSlackCommands(signingSecret string) Option {
+	return func(h *handlers) {
+		h.slackSecret = signingSecret
+	}
+}
+
+// WithApi enables the read-only JSON API under /api/v1
+func WithApi() Option {
+	return func(h *handlers) {
+		h.api = true
+	}
+}
+
+// WithApiTokens requires API tokens with the read scope on the read-only API,
+// and enables the admin API under /api/v1/admin for tokens with the admin
+// scope
+func WithApiTokens() Option {
+	return func(h *handlers) {
+		h.tokens = true
+	}
+}
+
+// WithDashboard serves the HTML dashboard under /dashboard
+func WithDashboard() Option {
+	return func(h *handlers) {
+		h.dashboard = true
+	}
+}
+
+// WithBadges enables the SVG stats badges of members who opted in
+func WithBadges() Option {
+	return func(h *handlers) {
+		h.badges = true
+	}
+}
+
+// WithMetrics enables the Prometheus metrics on /metrics
+func WithMetrics() Option {
+	return func(h *handlers) {
+		h.metrics = true
+	}
+}
+
+func NewMux(accessDb *db.DB, sender types.Sender, opts ...Option) *http.ServeMux {
+	h := handlers{db: accessDb, sender: sender, leaderboards: newLeaderboardCache()}
+	for _, opt := range opts {
+		opt(&h)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /udm", h.udmRequest)
+	mux.HandleFunc("GET /healthz", h.healthz)
+	mux.HandleFunc("GET /readyz", h.readyz)
+	if h.slackSecret != "" {
+		mux.HandleFunc("POST /slack/commands", h.slackCommand)
+	}
+	if h.api || h.tokens {
+		h.handleApi(mux)
+	}
+	if h.dashboard {
+		h.handleDashboard(mux)
+	}
+	if h.metrics {
+		mux.Handle("GET /metrics", metrics.Handler())
+	}
+	if h.badges {
+		mux.HandleFunc("GET /badge/{file}", h.memberBadge)
+	}
+	if h.events != nil {
+		mux.HandleFunc("GET /events", h.readFeed(h.eventStream))
+		mux.HandleFunc("GET /kiosk", h.readFeed(h.kiosk))
+	}
+	if h.dashboard || h.events != nil {
+		handleStatic(mux)
+	}
 	return mux
 }
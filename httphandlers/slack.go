@@ -0,0 +1,244 @@
+package httphandlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/types"
+	"github.com/slack-go/slack"
+)
+
+const (
+	topLimit  = 10
+	slackHelp = "Usage:\n" +
+		"• `/doorbot me`: your stats\n" +
+		"• `/doorbot stats <name>`: stats for a member\n" +
+		"• `/doorbot top`: members with the most visits\n" +
+		"• `/doorbot here`: who came in today\n" +
+		"• `/doorbot announce [public|achievements|never]`: which of your arrivals get announced\n" +
+		"• `/doorbot badge [on|off]`: whether your stats badge can be embedded"
+	slackUnlinked = "Your Slack user isn't linked to a member yet. Ask an admin to link it with `doorbot2 admin slack-user set`"
+)
+
+var announceHelp = map[types.Announce]string{
+	types.AnnouncePublic:       "always",
+	types.AnnounceAchievements: "only when a badge is earned",
+	types.AnnounceNever:        "never",
+}
+
+func (h handlers) slackCommand(w http.ResponseWriter, req *http.Request) {
+	verifier, err := slack.NewSecretsVerifier(req.Header, h.slackSecret)
+	if err != nil {
+		slog.Error("error verifying slack request", "err", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	req.Body = io.NopCloser(io.TeeReader(req.Body, &verifier))
+	cmd, err := slack.SlashCommandParse(req)
+	if err != nil {
+		slog.Error("error parsing slash command", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := verifier.Ensure(); err != nil {
+		slog.Warn("invalid slack signature", "err", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	slog.Info("Processing slash command", "user", cmd.UserName, "text", cmd.Text)
+	text, err := h.runSlackCommand(req.Context(), cmd)
+	if err != nil {
+		slog.Error("error running slash command", "text", cmd.Text, "err", err)
+		text = "Something went wrong, sorry :scream_cat:"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         text,
+	})
+}
+
+func (h handlers) runSlackCommand(ctx context.Context, cmd slack.SlashCommand) (string, error) {
+	sub, arg, _ := strings.Cut(strings.TrimSpace(cmd.Text), " ")
+	arg = strings.TrimSpace(arg)
+
+	switch sub {
+	case "me":
+		name, err := h.slackMember(ctx, cmd)
+		if err != nil {
+			return "", err
+		}
+		if name == "" {
+			return slackUnlinked, nil
+		}
+		return h.slackStats(ctx, name)
+	case "announce":
+		return h.slackAnnounce(ctx, cmd, arg)
+	case "badge":
+		return h.slackBadge(ctx, cmd, arg)
+	case "stats":
+		if arg == "" {
+			return "Whose stats? Try `/doorbot stats <name>`", nil
+		}
+		// Members who don't want their arrivals announced can only see
+		// their own stats, with "me"
+		optedOut, err := h.db.OptedOut(ctx)
+		if err != nil {
+			return "", err
+		}
+		if optedOut[arg] {
+			return fmt.Sprintf("No visits found for %q", arg), nil
+		}
+		return h.slackStats(ctx, arg)
+	case "top":
+		return h.slackTop(ctx)
+	case "here":
+		return h.slackHere(ctx)
+	default:
+		return slackHelp, nil
+	}
+}
+
+// slackMember returns the member mapped to whoever ran the command, or an
+// empty string if they aren't mapped. Slack handles aren't trusted, as anyone
+// can pick the door name of someone else.
+func (h handlers) slackMember(ctx context.Context, cmd slack.SlashCommand) (string, error) {
+	return h.db.MemberBySlackUser(ctx, cmd.UserID)
+}
+
+func (h handlers) slackAnnounce(ctx context.Context, cmd slack.SlashCommand, arg string) (string, error) {
+	name, err := h.slackMember(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return slackUnlinked, nil
+	}
+
+	if arg == "" {
+		a, err := h.db.Announce(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Arrivals of *%s* are announced: %s", name, announceHelp[a]), nil
+	}
+
+	a, err := types.ParseAnnounce(arg)
+	if err != nil {
+		return "Try `/doorbot announce public`, `achievements` or `never`", nil
+	}
+	if err := h.db.SetAnnounce(ctx, name, a); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Got it! Arrivals of *%s* will be announced: %s", name, announceHelp[a]), nil
+}
+
+func (h handlers) slackBadge(ctx context.Context, cmd slack.SlashCommand, arg string) (string, error) {
+	name, err := h.slackMember(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return slackUnlinked, nil
+	}
+
+	switch arg {
+	case "":
+	case "on", "off":
+		if err := h.db.SetBadgeShared(ctx, name, arg == "on"); err != nil {
+			return "", err
+		}
+	default:
+		return "Try `/doorbot badge on` or `off`", nil
+	}
+
+	shared, err := h.db.BadgeShared(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if !shared {
+		return fmt.Sprintf("The stats badge of *%s* isn't shared. Try `/doorbot badge on`", name), nil
+	}
+	return fmt.Sprintf("The stats badge of *%s* is shared at `/badge/%s.svg`", name, url.PathEscape(name)), nil
+}
+
+func (h handlers) slackStats(ctx context.Context, name string) (string, error) {
+	s, err := h.db.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if s.Last.IsZero() {
+		return fmt.Sprintf("No visits found for %q", name), nil
+	}
+	return formatStats(s), nil
+}
+
+// slackTop ranks the members with the most visits, leaving out those who
+// don't want their arrivals announced
+func (h handlers) slackTop(ctx context.Context) (string, error) {
+	optedOut, err := h.db.OptedOut(ctx)
+	if err != nil {
+		return "", err
+	}
+	top, err := h.db.Top(ctx, topLimit+len(optedOut))
+	if err != nil {
+		return "", err
+	}
+	top = slices.DeleteFunc(top, func(s types.Stats) bool { return optedOut[s.Name] })
+	top = top[:min(len(top), topLimit)]
+	if len(top) == 0 {
+		return "No visits yet", nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("*Most visits*")
+	for i, s := range top {
+		tier, _ := badges.Current().Total(s.Total)
+		fmt.Fprintf(&sb, "\n%d. %s %s %d", i+1, s.Name, tier.Emoji, s.Total)
+	}
+	return sb.String(), nil
+}
+
+func (h handlers) slackHere(ctx context.Context) (string, error) {
+	here, err := h.here(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(here) == 0 {
+		return "Nobody came in today", nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*Came in today (%d)*", len(here))
+	for _, r := range here {
+		fmt.Fprintf(&sb, "\n• %s (%s)", r.Name, r.Timestamp.In(h.db.Loc()).Format("15:04"))
+	}
+	return sb.String(), nil
+}
+
+func formatStats(s types.Stats) string {
+	conf := badges.Current()
+	tTier, _ := conf.Total(s.Total)
+	sTier, _ := conf.Streak(s.Streak)
+	return fmt.Sprintf(
+		"*%s*: %s %d visits, %s %d day streak. Last visit %s",
+		s.Name,
+		tTier.Emoji,
+		s.Total,
+		sTier.Emoji,
+		s.Streak,
+		s.Last.Format("Mon Jan 2 15:04"),
+	)
+}
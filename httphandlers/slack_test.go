@@ -0,0 +1,251 @@
+package httphandlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+	"github.com/slack-go/slack"
+)
+
+const (
+	signingSecret = "s3cr3t"
+)
+
+func slackReq(t *testing.T, secret, text string) *http.Request {
+	body := url.Values{
+		"command":   {"/doorbot"},
+		"text":      {text},
+		"user_id":   {"U123"},
+		"user_name": {username},
+	}.Encode()
+
+	ts := fmt.Sprint(time.Now().Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+
+	req, err := http.NewRequest(http.MethodPost, "/slack/commands", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestSlackCommand(t *testing.T) {
+	accessDb := getDb(t, "test_slack_command")
+	defer accessDb.Close()
+
+	ctx := context.Background()
+	now := time.Now().In(accessDb.Loc())
+	for _, r := range []types.AccessRecord{
+		{Timestamp: now.AddDate(0, 0, -1), Name: username, AccessGranted: true},
+		{Timestamp: now, Name: username, AccessGranted: true},
+		{Timestamp: now.AddDate(0, 0, -3), Name: "Other member", AccessGranted: true},
+		{Timestamp: now.AddDate(0, 0, -2), Name: "Shy", AccessGranted: true},
+		{Timestamp: now.AddDate(0, 0, -1), Name: "Shy", AccessGranted: true},
+		{Timestamp: now, Name: "Shy", AccessGranted: true},
+	} {
+		if _, err := accessDb.AddRecord(ctx, r); err != nil {
+			t.Fatalf("error adding record: %s", err)
+		}
+	}
+	if err := accessDb.SetAnnounce(ctx, "Shy", types.AnnounceNever); err != nil {
+		t.Fatalf("error setting preferences: %s", err)
+	}
+
+	mux := NewMux(accessDb, nil, WithSlackCommands(signingSecret))
+
+	// Sharing the door name of a member as Slack handle isn't enough to act
+	// on their behalf
+	for _, text := range []string{"me", "announce never", "badge on"} {
+		if got := slackText(t, mux, text); !strings.Contains(got, "isn't linked") {
+			t.Errorf("unexpected response for unlinked user to %q: %q", text, got)
+		}
+	}
+	if a, err := accessDb.Announce(ctx, username); err != nil || a != types.AnnouncePublic {
+		t.Errorf("unlinked user changed the preference to %q (err: %v)", a, err)
+	}
+	if shared, err := accessDb.BadgeShared(ctx, username); err != nil || shared {
+		t.Errorf("unlinked user shared the badge (err: %v)", err)
+	}
+	if err := accessDb.SetSlackUser(ctx, username, "U123"); err != nil {
+		t.Fatalf("error mapping slack user: %s", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		secret   string
+		text     string
+		wantCode int
+		wantText []string
+		dontWant []string
+	}{
+		{
+			name:     "Invalid signature",
+			secret:   "wrong secret",
+			text:     "me",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Me",
+			secret:   signingSecret,
+			text:     "me",
+			wantCode: http.StatusOK,
+			wantText: []string{"*dummy username*", "2 visits", "2 day streak"},
+		},
+		{
+			name:     "Stats",
+			secret:   signingSecret,
+			text:     "stats Other member",
+			wantCode: http.StatusOK,
+			wantText: []string{"*Other member*", "1 visits"},
+		},
+		{
+			name:     "Stats for unknown member",
+			secret:   signingSecret,
+			text:     "stats Nobody",
+			wantCode: http.StatusOK,
+			wantText: []string{`No visits found for "Nobody"`},
+		},
+		{
+			name:     "Stats for opted-out member",
+			secret:   signingSecret,
+			text:     "stats Shy",
+			wantCode: http.StatusOK,
+			wantText: []string{`No visits found for "Shy"`},
+		},
+		{
+			name:     "Top",
+			secret:   signingSecret,
+			text:     "top",
+			wantCode: http.StatusOK,
+			wantText: []string{"1. dummy username :fatcat: 2", "2. Other member :fatcat: 1"},
+			dontWant: []string{"Shy"},
+		},
+		{
+			name:     "Here",
+			secret:   signingSecret,
+			text:     "here",
+			wantCode: http.StatusOK,
+			wantText: []string{"Came in today (1)", "dummy username"},
+			dontWant: []string{"Shy"},
+		},
+		{
+			name:     "Announce preference",
+			secret:   signingSecret,
+			text:     "announce",
+			wantCode: http.StatusOK,
+			wantText: []string{"*dummy username*", "always"},
+		},
+		{
+			name:     "Set announce preference",
+			secret:   signingSecret,
+			text:     "announce never",
+			wantCode: http.StatusOK,
+			wantText: []string{"*dummy username*", "never"},
+		},
+		{
+			name:     "Invalid announce preference",
+			secret:   signingSecret,
+			text:     "announce sometimes",
+			wantCode: http.StatusOK,
+			wantText: []string{"Try `/doorbot announce public`"},
+		},
+		{
+			name:     "Share badge",
+			secret:   signingSecret,
+			text:     "badge on",
+			wantCode: http.StatusOK,
+			wantText: []string{"*dummy username*", "`/badge/dummy%20username.svg`"},
+		},
+		{
+			name:     "Invalid badge setting",
+			secret:   signingSecret,
+			text:     "badge maybe",
+			wantCode: http.StatusOK,
+			wantText: []string{"Try `/doorbot badge on`"},
+		},
+		{
+			name:     "Help",
+			secret:   signingSecret,
+			text:     "",
+			wantCode: http.StatusOK,
+			wantText: []string{"Usage:"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, slackReq(t, tt.secret, tt.text))
+
+			if resp.Code != tt.wantCode {
+				t.Fatalf("unexpected status code: %d. Wanted %d", resp.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var msg slack.Msg
+			if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+				t.Fatalf("error decoding response: %s", err)
+			}
+			if msg.ResponseType != slack.ResponseTypeEphemeral {
+				t.Errorf("response should be ephemeral")
+			}
+			for _, want := range tt.wantText {
+				if !strings.Contains(msg.Text, want) {
+					t.Errorf("%q not found in response %q", want, msg.Text)
+				}
+			}
+			for _, w := range tt.dontWant {
+				if strings.Contains(msg.Text, w) {
+					t.Errorf("%q found in response %q", w, msg.Text)
+				}
+			}
+		})
+	}
+	// "me" follows the mapping, not the Slack handle
+	if err := accessDb.DeleteSlackUser(ctx, username); err != nil {
+		t.Fatalf("error unmapping slack user: %s", err)
+	}
+	if err := accessDb.SetSlackUser(ctx, "Other member", "U123"); err != nil {
+		t.Fatalf("error mapping slack user: %s", err)
+	}
+	if got := slackText(t, mux, "me"); !strings.Contains(got, "*Other member*") {
+		t.Errorf("unexpected response for mapped user %q", got)
+	}
+
+	// Opted-out members still see their own stats
+	if err := accessDb.DeleteSlackUser(ctx, "Other member"); err != nil {
+		t.Fatalf("error unmapping slack user: %s", err)
+	}
+	if err := accessDb.SetSlackUser(ctx, "Shy", "U123"); err != nil {
+		t.Fatalf("error mapping slack user: %s", err)
+	}
+	if got := slackText(t, mux, "me"); !strings.Contains(got, "*Shy*") {
+		t.Errorf("unexpected response for opted-out user %q", got)
+	}
+}
+
+// slackText runs a command and returns the text of the reply
+func slackText(t *testing.T, mux http.Handler, text string) string {
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, slackReq(t, signingSecret, text))
+	var msg slack.Msg
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		t.Fatalf("error decoding response: %s", err)
+	}
+	return msg.Text
+}
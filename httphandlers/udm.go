@@ -1,11 +1,15 @@
 package httphandlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/logging"
+	"github.com/fatcatfablab/doorbot2/metrics"
 	"github.com/fatcatfablab/doorbot2/types"
 )
 
@@ -13,6 +17,31 @@ const (
 	granted = "Access Granted"
 )
 
+// Webhook results in the metrics
+const (
+	webhookInvalid = "invalid"
+	webhookExit    = "exit"
+	webhookIgnored = "ignored"
+	webhookDenied  = "denied"
+	webhookError   = "error"
+	webhookStored  = "stored"
+)
+
+// Event label of webhooks with an event not in udmEvents. The body isn't
+// authenticated, and every label value is a new series in the metrics.
+const otherEvent = "other"
+
+// UDM events counted by name in the metrics
+var udmEvents = map[string]bool{
+	"access.door.unlock":              true,
+	"access.doorbell.incoming":        true,
+	"access.doorbell.completed":       true,
+	"access.doorbell.incoming.REN":    true,
+	"access.device.dps_status":        true,
+	"access.device.emergency_status":  true,
+	"access.unlock_schedule.activate": true,
+}
+
 type udmMsg struct {
 	Event          string     `json:"event"`
 	EventObjectId  string     `json:"event_object_id"`
@@ -34,40 +63,89 @@ type udmActor struct {
 }
 
 type udmObject struct {
-	AuthenticationType  string `json:"authentication_type"`
-	AuthenticationValue string `json:"authentication_value"`
-	PolicyId            string `json:"policy_id"`
-	PolicyName          string `json:"policy_name"`
-	ReaderId            string `json:"reader_id"`
-	Result              string `json:"result"`
+	AuthenticationType string `json:"authentication_type"`
+	// Card number or PIN, never logged
+	AuthenticationValue logging.Secret `json:"authentication_value"`
+	PolicyId            string         `json:"policy_id"`
+	PolicyName          string         `json:"policy_name"`
+	ReaderId            string         `json:"reader_id"`
+	Result              string         `json:"result"`
+}
+
+func (a *udmActor) LogValue() slog.Value {
+	if a == nil {
+		return slog.Value{}
+	}
+	return slog.GroupValue(
+		slog.String("id", a.Id),
+		slog.String("name", a.Name),
+		slog.String("type", a.Type),
+	)
+}
+
+func (o *udmObject) LogValue() slog.Value {
+	if o == nil {
+		return slog.Value{}
+	}
+	return slog.GroupValue(
+		slog.String("authentication_type", o.AuthenticationType),
+		slog.Any("authentication_value", o.AuthenticationValue),
+		slog.String("policy_id", o.PolicyId),
+		slog.String("policy_name", o.PolicyName),
+		slog.String("reader_id", o.ReaderId),
+		slog.String("result", o.Result),
+	)
+}
+
+// door returns the name of the door in the message location, if any
+func (d udmMsgData) door() string {
+	name, _ := d.Location["name"].(string)
+	return name
+}
+
+// eventLabel returns the event label of a message in the metrics
+func (m udmMsg) eventLabel() string {
+	if udmEvents[m.Event] {
+		return m.Event
+	}
+	return otherEvent
 }
 
 func (h handlers) udmRequest(w http.ResponseWriter, req *http.Request) {
+	// Every line logged for the webhook carries these
+	ctx := logging.With(req.Context(), slog.String("request_id", requestId(req)))
 	j := json.NewDecoder(req.Body)
 	msg := udmMsg{}
 	if err := j.Decode(&msg); err != nil {
-		log.Printf("error parsing message: %s", err)
+		slog.ErrorContext(ctx, "error parsing message", "err", err)
+		metrics.Webhooks.Inc("", webhookInvalid)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	ctx = logging.With(ctx, slog.String("event_id", msg.EventObjectId))
+
 	if msg.Data.Object.AuthenticationType == "REX" {
 		// Exit request
+		metrics.Webhooks.Inc(msg.eventLabel(), webhookExit)
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	log.Printf(
-		"Processing UDM message: %+v %+v",
-		msg.Data.Actor,
-		msg.Data.Object,
+	slog.InfoContext(
+		ctx,
+		"Processing UDM message",
+		"event", msg.Event,
+		"door", msg.Data.door(),
+		"actor", msg.Data.Actor,
+		"object", msg.Data.Object,
 	)
 
 	var ts time.Time
 	if msg.TimeForTesting != nil {
-		ts = *msg.TimeForTesting
+		ts = msg.TimeForTesting.In(h.db.Loc())
 	} else {
-		ts = time.Now()
+		ts = time.Now().In(h.db.Loc())
 	}
 
 	r := types.AccessRecord{
@@ -76,24 +154,83 @@ func (h handlers) udmRequest(w http.ResponseWriter, req *http.Request) {
 		AccessGranted: msg.Data.Object.Result == granted,
 	}
 
-	if r.Name == "" || r.Name == "N/A" || !r.AccessGranted {
+	if r.Name == "" || r.Name == "N/A" {
+		metrics.Webhooks.Inc(msg.eventLabel(), webhookIgnored)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !r.AccessGranted {
+		metrics.Webhooks.Inc(msg.eventLabel(), webhookDenied)
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	s, bumped, err := h.db.AddRecord(req.Context(), r)
+	v, err := h.db.AddRecord(ctx, r)
 	if err != nil {
-		log.Printf("error bumping %s: %s", msg.Data.Actor.Name, err)
+		slog.ErrorContext(ctx, "error bumping", "member", r.Name, "err", err)
+		metrics.Webhooks.Inc(msg.eventLabel(), webhookError)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	metrics.Webhooks.Inc(msg.eventLabel(), webhookStored)
+
+	// Achievements can be earned on later visits the same day, which don't
+	// bump the stats but are still worth announcing
+	a := types.Arrival{Record: r, Stats: v.Stats, Door: msg.Data.door(), Achievements: v.Awards}
+	if !v.Prev.Last.IsZero() {
+		a.DaysAway = db.DaysBetween(v.Prev.Last, r.Timestamp)
+		if v.Prev.Streak > 1 && v.Stats.Streak == 1 {
+			a.BrokenStreak = v.Prev.Streak
+		}
+	}
 
-	if bumped && h.slack != nil {
-		err = h.slack.Post(req.Context(), s)
-		if err != nil {
-			log.Printf("error posting message to slack: %s", err)
+	// Every visit shows up in the live feed, but only those bumping the stats
+	// or earning something get announced
+	live := h.events != nil
+	post := (v.Bumped || len(v.Awards) > 0) && h.sender != nil
+	if (live || post) && h.announce(ctx, a) {
+		if live {
+			h.events.Publish(a)
+		}
+		if post {
+			err = h.sender.Post(ctx, a)
+			if err != nil {
+				slog.ErrorContext(ctx, "error posting arrival", "err", err)
+			}
 		}
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// requestId returns the id the request came with from a proxy, or a new one
+func requestId(req *http.Request) string {
+	if id := req.Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	return logging.NewId()
+}
+
+// announce reports whether the member preferences allow announcing an
+// arrival. Members whose preferences can't be read aren't announced, just in
+// case.
+func (h handlers) announce(ctx context.Context, arrival types.Arrival) bool {
+	s := arrival.Stats
+	a, err := h.db.Announce(ctx, s.Name)
+	if err != nil {
+		slog.ErrorContext(ctx, "error getting preferences", "member", s.Name, "err", err)
+		return false
+	}
+
+	switch a {
+	case types.AnnounceNever:
+		slog.InfoContext(ctx, "Not announcing: never announce", "member", s.Name)
+		return false
+	case types.AnnounceAchievements:
+		if len(arrival.Achievements) == 0 {
+			slog.InfoContext(ctx, "Not announcing: only achievements are announced", "member", s.Name)
+			return false
+		}
+	}
+	return true
+}
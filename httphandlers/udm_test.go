@@ -6,13 +6,17 @@ import (
 	"database/sql"
 	"encoding/json"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/fatcatfablab/doorbot2/achievements"
 	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/logging"
+	"github.com/fatcatfablab/doorbot2/metrics"
 	"github.com/fatcatfablab/doorbot2/types"
 )
 
@@ -25,11 +29,13 @@ const (
 )
 
 type MockSender struct {
-	posted bool
+	posted  bool
+	arrival types.Arrival
 }
 
-func (s *MockSender) Post(_ context.Context, stats types.Stats) error {
+func (s *MockSender) Post(_ context.Context, a types.Arrival) error {
 	s.posted = true
+	s.arrival = a
 	return nil
 }
 
@@ -65,7 +71,7 @@ func getDb(t *testing.T, dbName string) *db.DB {
 		t.Fatalf("can't create database %s: %s", dbName, err)
 	}
 
-	db, err := db.New(dsn+dbName, tz)
+	db, err := db.New(dsn+dbName, tz, achievements.Default())
 	if err != nil {
 		t.Fatalf("can't connect to test db: %s", err)
 	}
@@ -205,3 +211,215 @@ func TestUdmRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestUdmRequestPreferences(t *testing.T) {
+	accessDb := getDb(t, "test_udm_preferences")
+	defer accessDb.Close()
+
+	ctx := context.Background()
+	if err := accessDb.SetAnnounce(ctx, "Quiet", types.AnnounceNever); err != nil {
+		t.Fatalf("error setting preference: %s", err)
+	}
+	if err := accessDb.SetAnnounce(ctx, "Modest", types.AnnounceAchievements); err != nil {
+		t.Fatalf("error setting preference: %s", err)
+	}
+
+	start := time.Date(2025, 1, 20, 18, 0, 0, 0, accessDb.Loc())
+	for _, tt := range []struct {
+		name      string
+		member    string
+		day       int
+		postSlack bool
+	}{
+		{"Public", username, 0, true},
+		{"Never", "Quiet", 0, false},
+		{"Achievements without badge", "Modest", 0, false},
+		{"Achievements without badge, day 2", "Modest", 1, false},
+		{"Achievements without badge, day 3", "Modest", 2, false},
+		{"Achievements without badge, day 4", "Modest", 3, false},
+		{"Achievements with streak badge", "Modest", 4, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := start.AddDate(0, 0, tt.day)
+			slackSender := MockSender{}
+			mux := NewMux(accessDb, &slackSender)
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, udmReqBuilderFromMsg(udmMsg{
+				Data: udmMsgData{
+					Actor:  &udmActor{Name: tt.member},
+					Object: &udmObject{Result: granted},
+				},
+				TimeForTesting: &ts,
+			})(t))
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("unexpected status code: %d", resp.Code)
+			}
+			if tt.postSlack != slackSender.posted {
+				log.Printf("want slack: %t", tt.postSlack)
+				log.Printf("got  slack: %t", slackSender.posted)
+				t.Errorf("unexpected slack call/no call")
+			}
+
+			// History is recorded regardless of the preference
+			s, err := accessDb.Get(ctx, tt.member)
+			if err != nil {
+				t.Fatalf("error getting stats: %s", err)
+			}
+			if !s.Last.Equal(ts) {
+				t.Errorf("arrival not recorded: %+v", s)
+			}
+		})
+	}
+}
+
+func TestUdmRequestComeback(t *testing.T) {
+	accessDb := getDb(t, "test_udm_comeback")
+	defer accessDb.Close()
+
+	start := time.Date(2025, 1, 1, 18, 0, 0, 0, accessDb.Loc())
+	for _, tt := range []struct {
+		name             string
+		ts               time.Time
+		wantDaysAway     uint
+		wantBrokenStreak uint
+	}{
+		{"First visit", start, 0, 0},
+		{"Streak", start.AddDate(0, 0, 1), 1, 0},
+		{"Streak again", start.AddDate(0, 0, 2), 1, 0},
+		{"Comeback", start.AddDate(0, 3, 2), 90, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			slackSender := MockSender{}
+			mux := NewMux(accessDb, &slackSender)
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, udmReqBuilderFromMsg(udmMsg{
+				Data: udmMsgData{
+					Actor:  &udmActor{Name: username},
+					Object: &udmObject{Result: granted},
+				},
+				TimeForTesting: &tt.ts,
+			})(t))
+
+			if !slackSender.posted {
+				t.Fatalf("arrival not posted")
+			}
+			a := slackSender.arrival
+			if a.DaysAway != tt.wantDaysAway || a.BrokenStreak != tt.wantBrokenStreak {
+				log.Printf("want: %d days away, broken streak %d", tt.wantDaysAway, tt.wantBrokenStreak)
+				log.Printf("got : %d days away, broken streak %d", a.DaysAway, a.BrokenStreak)
+				t.Errorf("arrivals differ")
+			}
+		})
+	}
+}
+
+func TestUdmRequestMetrics(t *testing.T) {
+	accessDb := getDb(t, "test_udm_metrics")
+	defer accessDb.Close()
+
+	const event = "access.door.unlock"
+	before := map[string]float64{}
+	for _, result := range []string{webhookStored, webhookDenied, webhookExit} {
+		before[result] = metrics.Webhooks.Value(event, result)
+	}
+	stored := metrics.RecordsStored.Value()
+
+	mux := NewMux(accessDb, nil, WithMetrics())
+	ts := time.Date(2025, 1, 20, 12, 0, 0, 0, accessDb.Loc())
+	for _, obj := range []udmObject{
+		{Result: granted},
+		{Result: "Access Denied"},
+		{AuthenticationType: "REX"},
+	} {
+		mux.ServeHTTP(httptest.NewRecorder(), udmReqBuilderFromMsg(udmMsg{
+			Event: event,
+			Data: udmMsgData{
+				Actor:  &udmActor{Name: username},
+				Object: &obj,
+			},
+			TimeForTesting: &ts,
+		})(t))
+	}
+
+	for result, n := range before {
+		if got := metrics.Webhooks.Value(event, result); got != n+1 {
+			t.Errorf("unexpected %s webhooks %v, want %v", result, got, n+1)
+		}
+	}
+	if got := metrics.RecordsStored.Value(); got != stored+1 {
+		t.Errorf("unexpected records stored %v, want %v", got, stored+1)
+	}
+
+	// Unknown events don't get a label value of their own
+	other := metrics.Webhooks.Value(otherEvent, webhookDenied)
+	mux.ServeHTTP(httptest.NewRecorder(), udmReqBuilderFromMsg(udmMsg{
+		Event: "made.up.event",
+		Data: udmMsgData{
+			Actor:  &udmActor{Name: username},
+			Object: &udmObject{Result: "Access Denied"},
+		},
+		TimeForTesting: &ts,
+	})(t))
+	if got := metrics.Webhooks.Value(otherEvent, webhookDenied); got != other+1 {
+		t.Errorf("unexpected other webhooks %v, want %v", got, other+1)
+	}
+
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(resp.Body.String(), "made.up.event") {
+		t.Errorf("unknown event found in:\n%s", resp.Body)
+	}
+	want := `doorbot2_webhooks_total{event="access.door.unlock",result="stored"}`
+	if !strings.Contains(resp.Body.String(), want) {
+		t.Errorf("%q not found in:\n%s", want, resp.Body)
+	}
+}
+
+func TestUdmRequestLogging(t *testing.T) {
+	accessDb := getDb(t, "test_udm_logging")
+	defer accessDb.Close()
+
+	var buf bytes.Buffer
+	l, err := logging.New(&buf, logging.FormatJSON, "debug")
+	if err != nil {
+		t.Fatalf("error creating logger: %s", err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(l)
+
+	// So announcing logs too
+	if err := accessDb.SetAnnounce(context.Background(), username, types.AnnounceNever); err != nil {
+		t.Fatalf("error setting preferences: %s", err)
+	}
+
+	ts := time.Date(2025, 1, 20, 12, 0, 0, 0, accessDb.Loc())
+	req := udmReqBuilderFromMsg(udmMsg{
+		Event:         "access.door.unlock",
+		EventObjectId: "event-1",
+		Data: udmMsgData{
+			Actor:  &udmActor{Name: username},
+			Object: &udmObject{Result: granted, AuthenticationType: "NFC", AuthenticationValue: "0123456789"},
+		},
+		TimeForTesting: &ts,
+	})(t)
+	req.Header.Set("X-Request-Id", "req-1")
+	NewMux(accessDb, &MockSender{}).ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected a few log lines, got:\n%s", buf.String())
+	}
+	for _, line := range lines {
+		var got map[string]any
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("error decoding %q: %s", line, err)
+		}
+		if got["request_id"] != "req-1" || got["event_id"] != "event-1" {
+			t.Errorf("missing ids in %s", line)
+		}
+	}
+	if strings.Contains(buf.String(), "0123456789") || !strings.Contains(buf.String(), logging.Redacted) {
+		t.Errorf("authentication value not redacted:\n%s", buf.String())
+	}
+}
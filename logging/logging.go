@@ -0,0 +1,102 @@
+// Package logging sets up structured logging with log/slog, and carries
+// attributes like request ids in contexts so every line logged while
+// handling a request has them.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	// Replaces secrets in log lines
+	Redacted = "[redacted]"
+)
+
+type ctxKey struct{}
+
+// New returns a logger writing lines from level up to w, as logfmt style
+// text or JSON
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	var h slog.Handler
+	switch format {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// Setup makes a logger like New's the default one, which the log package
+// writes through too
+func Setup(w io.Writer, format, level string) error {
+	l, err := New(w, format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(l)
+	return nil
+}
+
+// With returns a context whose log lines have attrs too, when logged with the
+// slog functions taking a context
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	return context.WithValue(ctx, ctxKey{}, append(slices.Clip(prev), attrs...))
+}
+
+// NewId returns a random id, like for requests
+func NewId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Secret logs as Redacted, unless empty, so log lines still tell whether
+// there was one
+type Secret string
+
+func (s Secret) LogValue() slog.Value {
+	if s == "" {
+		return slog.StringValue("")
+	}
+	return slog.StringValue(Redacted)
+}
+
+// contextHandler adds the attributes in the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(ctxKey{}).([]slog.Attr); ok {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		format  string
+		level   string
+		wantErr bool
+	}{
+		{"Text", FormatText, "info", false},
+		{"JSON", FormatJSON, "DEBUG", false},
+		{"Invalid format", "xml", "info", true},
+		{"Invalid level", FormatText, "loud", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(&bytes.Buffer{}, tt.format, tt.level)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, FormatJSON, "info")
+	if err != nil {
+		t.Fatalf("error creating logger: %s", err)
+	}
+
+	ctx := With(context.Background(), slog.String("request_id", "abc"))
+	ctx = With(ctx, slog.String("event_id", "123"))
+	l.With("component", "test").InfoContext(ctx, "Arrival", "pin", Secret("1234"), "card", Secret(""))
+	l.DebugContext(ctx, "Not logged")
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("error decoding %q: %s", buf.String(), err)
+	}
+	want := map[string]any{
+		"msg":        "Arrival",
+		"request_id": "abc",
+		"event_id":   "123",
+		"component":  "test",
+		"pin":        Redacted,
+		"card":       "",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			log.Printf("want: %v", want)
+			log.Printf("got : %v", got)
+			t.Errorf("unexpected %s %v", k, got[k])
+		}
+	}
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("unexpected lines:\n%s", buf.String())
+	}
+}
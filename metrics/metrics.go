@@ -0,0 +1,263 @@
+// Package metrics keeps a few counters, histograms and gauges, and serves
+// them in the Prometheus text format. It only covers what doorbot2 needs,
+// which isn't worth pulling in the Prometheus client for.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buckets for durations in seconds, from 1ms to 10s
+var DurationBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	// Webhooks counts the UDM webhooks received by event type and result
+	Webhooks = NewCounter("doorbot2_webhooks_total", "UDM webhooks received, by event type and result.", "event", "result")
+	// RecordsStored counts access records stored in the database
+	RecordsStored = NewCounter("doorbot2_records_stored_total", "Access records stored.")
+	// SlackPosts counts messages and DMs posted to Slack, by result
+	SlackPosts = NewCounter("doorbot2_slack_posts_total", "Slack messages posted, by result.", "result")
+	// TxDuration measures database transactions, by operation and result
+	TxDuration = NewHistogram("doorbot2_db_tx_duration_seconds", "Duration of database transactions, by operation and result.", DurationBuckets, "op", "result")
+)
+
+type metric interface {
+	write(w *bytes.Buffer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+// Counter is a counter with a series per combination of label values
+type Counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]float64
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, series: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the series with the label values, given in the order the
+// labels were
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(n float64, values ...string) {
+	key := labelPairs(c.labels, values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.series[key] += n
+}
+
+// Value returns the current value of a series
+func (c *Counter) Value(values ...string) float64 {
+	key := labelPairs(c.labels, values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.series[key]
+}
+
+func (c *Counter) write(w *bytes.Buffer) {
+	header(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.series) {
+		sample(w, c.name, key, c.series[key])
+	}
+}
+
+// Histogram counts observations in cumulative buckets, with a series per
+// combination of label values
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	// Observations up to each bucket, not cumulative yet
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: slices.Sorted(slices.Values(buckets)),
+		series:  make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := labelPairs(h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns how many observations a series has
+func (h *Histogram) Count(values ...string) uint64 {
+	key := labelPairs(h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bytes.Buffer) {
+	header(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, b := range h.buckets {
+			cumulative += s.counts[i]
+			sample(w, h.name+"_bucket", join(key, `le="`+formatFloat(b)+`"`), float64(cumulative))
+		}
+		sample(w, h.name+"_bucket", join(key, `le="+Inf"`), float64(s.count))
+		sample(w, h.name+"_sum", key, s.sum)
+		sample(w, h.name+"_count", key, float64(s.count))
+	}
+}
+
+// GaugeFunc is a gauge read when scraped, like the length of a queue
+type GaugeFunc struct {
+	name, help string
+	f          func() (float64, error)
+}
+
+// NewGaugeFunc registers a gauge taking its value from f. Errors are logged
+// and leave the gauge out of the scrape.
+func NewGaugeFunc(name, help string, f func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, f: f}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bytes.Buffer) {
+	v, err := g.f()
+	if err != nil {
+		slog.Error("error reading gauge", "gauge", g.name, "err", err)
+		return
+	}
+	header(w, g.name, g.help, "gauge")
+	sample(w, g.name, "", v)
+}
+
+// Write writes every metric in the Prometheus text format
+func Write(w *bytes.Buffer) {
+	registryMu.Lock()
+	metrics := slices.Clone(registry)
+	registryMu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var buf bytes.Buffer
+		Write(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := buf.WriteTo(w); err != nil {
+			slog.Error("error writing metrics", "err", err)
+		}
+	})
+}
+
+func header(w *bytes.Buffer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sample(w *bytes.Buffer, name, labels string, v float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(v))
+}
+
+// labelPairs formats labels like a="1",b="2". Missing values are empty, and
+// extra ones ignored.
+func labelPairs(labels, values []string) string {
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs[i] = l + `="` + escape(v) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	c := &Counter{name: "test_total", help: "Test.", labels: []string{"event", "result"}, series: make(map[string]float64)}
+	c.Inc("access.door.unlock", "granted")
+	c.Inc("access.door.unlock", "granted")
+	c.Inc(`we"ird`, "denied")
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	want := `# HELP test_total Test.
+# TYPE test_total counter
+test_total{event="access.door.unlock",result="granted"} 2
+test_total{event="we\"ird",result="denied"} 1
+`
+	if got := buf.String(); got != want {
+		log.Printf("want: %s", want)
+		log.Printf("got : %s", got)
+		t.Errorf("unexpected counter output")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := &Histogram{name: "test_seconds", help: "Test.", labels: []string{"op"}, buckets: []float64{.1, 1}, series: make(map[string]*histogramSeries)}
+	h.Observe(.05, "add")
+	h.Observe(.5, "add")
+	h.Observe(2, "add")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	want := `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="add",le="0.1"} 1
+test_seconds_bucket{op="add",le="1"} 2
+test_seconds_bucket{op="add",le="+Inf"} 3
+test_seconds_sum{op="add"} 2.55
+test_seconds_count{op="add"} 3
+`
+	if got := buf.String(); got != want {
+		log.Printf("want: %s", want)
+		log.Printf("got : %s", got)
+		t.Errorf("unexpected histogram output")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	NewGaugeFunc("test_gauge", "Test.", func() (float64, error) { return 3, nil })
+	NewGaugeFunc("test_broken_gauge", "Test.", func() (float64, error) { return 0, errors.New("broken") })
+	RecordsStored.Inc()
+
+	resp := httptest.NewRecorder()
+	Handler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := resp.Body.String()
+	for _, want := range []string{"\ntest_gauge 3\n", "\ndoorbot2_records_stored_total 1\n", "# TYPE doorbot2_db_tx_duration_seconds histogram\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%q not found in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "test_broken_gauge") {
+		t.Errorf("broken gauge found in:\n%s", body)
+	}
+}
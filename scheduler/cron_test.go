@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	tz = "America/New_York"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected error parsing %q", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+
+	// A Wednesday
+	from := time.Date(2025, 1, 15, 10, 30, 0, 0, loc)
+	for _, tt := range []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "* * * * *", want: time.Date(2025, 1, 15, 10, 31, 0, 0, loc)},
+		{spec: "*/15 * * * *", want: time.Date(2025, 1, 15, 10, 45, 0, 0, loc)},
+		{spec: "0 8 * * *", want: time.Date(2025, 1, 16, 8, 0, 0, 0, loc)},
+		{spec: "@daily", want: time.Date(2025, 1, 16, 0, 0, 0, 0, loc)},
+		{spec: "@weekly", want: time.Date(2025, 1, 20, 0, 0, 0, 0, loc)},
+		{spec: "@monthly", want: time.Date(2025, 2, 1, 0, 0, 0, 0, loc)},
+		{spec: "0 9 * * 0", want: time.Date(2025, 1, 19, 9, 0, 0, 0, loc)},
+		{spec: "0 9 * * 7", want: time.Date(2025, 1, 19, 9, 0, 0, 0, loc)},
+		{spec: "30 10,18 * * 1-5", want: time.Date(2025, 1, 15, 18, 30, 0, 0, loc)},
+		{spec: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, loc)},
+		// Either day of month or day of week
+		{spec: "0 12 1 * 5", want: time.Date(2025, 1, 17, 12, 0, 0, 0, loc)},
+		// Skips the nonexistent 2:30 on the DST change
+		{spec: "30 2 9 3 *", want: time.Date(2026, 3, 9, 2, 30, 0, 0, loc)},
+		{spec: "0 2-3 9 3 *", want: time.Date(2025, 3, 9, 3, 0, 0, 0, loc)},
+	} {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("error parsing: %s", err)
+			}
+			got := s.Next(from)
+			if !got.Equal(tt.want) {
+				t.Errorf("unexpected next run %s. Wanted %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context, now time.Time) error
+}
+
+// Scheduler runs jobs on their schedules, evaluated in its location
+type Scheduler struct {
+	loc  *time.Location
+	jobs []Job
+}
+
+func New(loc *time.Location) *Scheduler {
+	return &Scheduler{loc: loc}
+}
+
+func (s *Scheduler) Add(j Job) {
+	s.jobs = append(s.jobs, j)
+}
+
+func (s *Scheduler) Len() int {
+	return len(s.jobs)
+}
+
+// Run blocks running jobs until ctx is cancelled. Jobs are independent of
+// each other, and a failing run is logged and doesn't stop later ones.
+func (s *Scheduler) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, j Job) {
+	for {
+		next := j.Schedule.Next(time.Now().In(s.loc))
+		if next.IsZero() {
+			slog.Info("Job will never run again", "job", j.Name)
+			return
+		}
+		slog.Debug("Next job run", "job", j.Name, "at", next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		slog.Info("Running job", "job", j.Name)
+		if err := j.Run(ctx, next); err != nil {
+			slog.Error("error running job", "job", j.Name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package sender
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+var (
+	//go:embed templates/announcement.tmpl
+	defaultAnnouncement string
+)
+
+// AnnouncementData is what announcement templates get rendered with
+type AnnouncementData struct {
+	Name string
+	// Slack user id of the member, when mentions are enabled and the member
+	// is mapped to a Slack user
+	SlackUser string
+	// "<@SlackUser>" when SlackUser is known, or Name otherwise
+	Mention      string
+	Total        uint
+	Streak       uint
+	TotalBadge   badges.Tier
+	StreakBadge  badges.Tier
+	TotalEarned  bool
+	StreakEarned bool
+	Door         string
+	// Days since the previous visit, and whether that's long enough to
+	// welcome the member back
+	DaysAway uint
+	Comeback bool
+	// Streak broken by this visit, and whether it was long enough to mention
+	BrokenStreak uint
+	StreakLost   bool
+	// Achievements other than badges awarded for this visit
+	Achievements []types.Achievement
+	Time         time.Time
+	// One of "morning", "afternoon", "evening" or "night"
+	TimeOfDay string
+}
+
+// Announcer renders arrivals into messages using a text/template
+type Announcer struct {
+	tmpl *template.Template
+}
+
+// NewAnnouncer loads the announcement template at path, or the built-in one
+// if path is empty. The template is test rendered so mistakes like unknown
+// fields are caught at load time instead of on the next arrival.
+func NewAnnouncer(path string) (*Announcer, error) {
+	name := "announcement"
+	text := defaultAnnouncement
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading template: %w", err)
+		}
+		name = filepath.Base(path)
+		text = string(b)
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %w", err)
+	}
+
+	a := &Announcer{tmpl: tmpl}
+	if _, err := a.Render(sampleArrival()); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Announcer) Render(arrival types.Arrival) (string, error) {
+	return a.RenderData(NewAnnouncementData(arrival))
+}
+
+func (a *Announcer) RenderData(d AnnouncementData) (string, error) {
+	var sb strings.Builder
+	if err := a.tmpl.Execute(&sb, d); err != nil {
+		return "", fmt.Errorf("error rendering announcement: %w", err)
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+func NewAnnouncementData(a types.Arrival) AnnouncementData {
+	conf := badges.Current()
+	tBadge, _ := conf.Total(a.Stats.Total)
+	sBadge, _ := conf.Streak(a.Stats.Streak)
+
+	// Badges come along with the achievements, but are told apart so they're
+	// announced with the tiers
+	var tEarned, sEarned bool
+	var awards []types.Achievement
+	for _, award := range a.Achievements {
+		switch {
+		case strings.HasPrefix(award.Id, badges.TotalPrefix):
+			tEarned = true
+		case strings.HasPrefix(award.Id, badges.StreakPrefix):
+			sEarned = true
+		default:
+			awards = append(awards, award)
+		}
+	}
+
+	ts := a.Record.Timestamp
+	if ts.IsZero() {
+		ts = a.Stats.Last
+	}
+
+	return AnnouncementData{
+		Name:         a.Stats.Name,
+		Mention:      a.Stats.Name,
+		Total:        a.Stats.Total,
+		Streak:       a.Stats.Streak,
+		TotalBadge:   tBadge,
+		StreakBadge:  sBadge,
+		TotalEarned:  tEarned,
+		StreakEarned: sEarned,
+		Door:         a.Door,
+		DaysAway:     a.DaysAway,
+		Comeback:     conf.Comeback(a.DaysAway),
+		BrokenStreak: a.BrokenStreak,
+		StreakLost:   conf.StreakLost(a.BrokenStreak),
+		Achievements: awards,
+		Time:         ts,
+		TimeOfDay:    timeOfDay(ts),
+	}
+}
+
+// earned reports whether any badge or achievement was earned on this visit
+func (d AnnouncementData) earned() bool {
+	return d.TotalEarned || d.StreakEarned || len(d.Achievements) > 0
+}
+
+// WithSlackUser returns a copy of d mentioning the given Slack user
+func (d AnnouncementData) WithSlackUser(id string) AnnouncementData {
+	if id != "" {
+		d.SlackUser = id
+		d.Mention = fmt.Sprintf("<@%s>", id)
+	}
+	return d
+}
+
+func timeOfDay(t time.Time) string {
+	switch h := t.Hour(); {
+	case h >= 5 && h < 12:
+		return "morning"
+	case h >= 12 && h < 18:
+		return "afternoon"
+	case h >= 18 && h < 22:
+		return "evening"
+	default:
+		return "night"
+	}
+}
+
+func sampleArrival() types.Arrival {
+	ts := time.Date(2025, 1, 20, 18, 0, 0, 0, time.UTC)
+	return types.Arrival{
+		Record: types.AccessRecord{Timestamp: ts, Name: "Johnny Melavo", AccessGranted: true},
+		Stats:  types.Stats{Name: "Johnny Melavo", Total: 7, Streak: 5, Last: ts},
+		Door:   "Front Door",
+		// Test render the medal lines too
+		Achievements: badges.Current().Earned(7, 5),
+	}
+}
@@ -0,0 +1,85 @@
+package sender
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+// earned returns the badges awarded on reaching stats s, followed by awards
+func earned(s types.Stats, awards ...types.Achievement) []types.Achievement {
+	return append(badges.Current().Earned(s.Total, s.Streak), awards...)
+}
+
+func writeTemplate(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "announcement.tmpl")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatalf("error writing template: %s", err)
+	}
+	return path
+}
+
+func TestCustomAnnouncement(t *testing.T) {
+	path := writeTemplate(t,
+		`Good {{ .TimeOfDay }}, {{ .Name }}! {{ .Door }} at {{ .Time.Format "15:04" }}. `+
+			`{{ if .TotalEarned }}New medal: {{ .TotalBadge.Msg }}{{ else }}Visit #{{ .Total }}{{ end }}`,
+	)
+	announcer, err := NewAnnouncer(path)
+	if err != nil {
+		t.Fatalf("error loading template: %s", err)
+	}
+
+	ts := time.Date(2025, 1, 20, 9, 30, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name  string
+		stats types.Stats
+		want  string
+	}{
+		{
+			name:  "Regular visit",
+			stats: types.Stats{Name: name, Total: 3, Streak: 1},
+			want:  "Good morning, Johnny Melavo! Back door at 09:30. Visit #3",
+		},
+		{
+			name:  "Medal earned",
+			stats: types.Stats{Name: name, Total: 7, Streak: 1},
+			want:  "Good morning, Johnny Melavo! Back door at 09:30. New medal: UNO",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := announcer.Render(types.Arrival{
+				Record: types.AccessRecord{Timestamp: ts, Name: name, AccessGranted: true},
+				Stats:  tt.stats,
+				Door:   "Back door",
+
+				Achievements: earned(tt.stats),
+			})
+			if err != nil {
+				t.Fatalf("error rendering: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected announcement %q", got)
+			}
+		})
+	}
+}
+
+func TestInvalidAnnouncement(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		text string
+	}{
+		{name: "Syntax error", text: "{{ .Name "},
+		{name: "Unknown field", text: "{{ .Nickname }}"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAnnouncer(writeTemplate(t, tt.text)); err == nil {
+				t.Error("expected an error loading the template")
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package sender
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/slack-go/slack"
+)
+
+const (
+	progressWidth = 10
+)
+
+// arrivalBlocks lays out an arrival as Block Kit blocks: the member stats as
+// fields, a hint on how far the next medal is, and a highlighted section for
+// badges earned on this visit
+func arrivalBlocks(d AnnouncementData) []slack.Block {
+	title := fmt.Sprintf("*%s* arrived", d.Name)
+	if d.SlackUser != "" {
+		title = fmt.Sprintf("%s arrived", d.Mention)
+	}
+	if d.Door != "" {
+		title += fmt.Sprintf(" through %s", d.Door)
+	}
+
+	blocks := []slack.Block{
+		slack.NewSectionBlock(
+			mrkdwn(title),
+			[]*slack.TextBlockObject{
+				mrkdwn(fmt.Sprintf("*Total*\n%s %d", d.TotalBadge.Emoji, d.Total)),
+				mrkdwn(fmt.Sprintf("*Streak*\n%s %d", d.StreakBadge.Emoji, d.Streak)),
+			},
+			nil,
+		),
+	}
+
+	var notes []slack.MixedElement
+	if d.Comeback {
+		notes = append(notes, mrkdwn(fmt.Sprintf(":wave: Welcome back after %d days!", d.DaysAway)))
+	}
+	if d.StreakLost {
+		notes = append(notes, mrkdwn(fmt.Sprintf(":broken_heart: New streak started (previous: %d)", d.BrokenStreak)))
+	}
+	if len(notes) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", notes...))
+	}
+
+	if next, ok := badges.Current().NextTotal(d.Total); ok {
+		left := next.Threshold + 1 - d.Total
+		visits := "visits"
+		if left == 1 {
+			visits = "visit"
+		}
+		blocks = append(blocks, slack.NewContextBlock(
+			"",
+			mrkdwn(fmt.Sprintf(
+				"`%s` %d %s to %s %s",
+				progressBar(d.TotalBadge, next, d.Total),
+				left,
+				visits,
+				next.Msg,
+				next.Emoji,
+			)),
+		))
+	}
+
+	if d.TotalEarned || d.StreakEarned || len(d.Achievements) > 0 {
+		blocks = append(blocks, slack.NewDividerBlock())
+	}
+	if d.TotalEarned {
+		blocks = append(blocks, slack.NewSectionBlock(
+			mrkdwn(fmt.Sprintf(
+				":tada: *Achievement unlocked!* You get the *%s* medal: %s",
+				d.TotalBadge.Msg,
+				d.TotalBadge.Emoji,
+			)),
+			nil,
+			nil,
+		))
+	}
+	if d.StreakEarned {
+		blocks = append(blocks, slack.NewSectionBlock(
+			mrkdwn(fmt.Sprintf(":fire: *%s* %s", d.StreakBadge.Msg, d.StreakBadge.Emoji)),
+			nil,
+			nil,
+		))
+	}
+
+	for _, a := range d.Achievements {
+		blocks = append(blocks, slack.NewSectionBlock(
+			mrkdwn(fmt.Sprintf(":medal: *%s* %s", a.Msg, a.Emoji)),
+			nil,
+			nil,
+		))
+	}
+
+	return blocks
+}
+
+// progressBar draws how far total is from earning next, starting from the
+// visit that earned the current tier
+func progressBar(current, next badges.Tier, total uint) string {
+	from := uint(0)
+	if current.Emoji != "" {
+		from = current.Threshold + 1
+	}
+	to := next.Threshold + 1
+
+	filled := 0
+	if to > from && total > from {
+		filled = int((total - from) * progressWidth / (to - from))
+	}
+	return strings.Repeat("▓", filled) + strings.Repeat("░", progressWidth-filled)
+}
+
+func mrkdwn(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.MarkdownType, text, false, false)
+}
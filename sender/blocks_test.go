@@ -0,0 +1,92 @@
+package sender
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/types"
+	"github.com/slack-go/slack"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestArrivalBlocks(t *testing.T) {
+	ts := time.Date(2025, 1, 20, 18, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name         string
+		stats        types.Stats
+		door         string
+		daysAway     uint
+		brokenStreak uint
+		achievements []types.Achievement
+	}{
+		{name: "first_visit", stats: types.Stats{Name: name, Total: 1, Streak: 1}},
+		{name: "regular_visit", stats: types.Stats{Name: name, Total: 4, Streak: 2}, door: "Front Door"},
+		{name: "medal_earned", stats: types.Stats{Name: name, Total: 7, Streak: 2}},
+		{name: "medal_and_streak", stats: types.Stats{Name: name, Total: 31, Streak: 14}},
+		{name: "last_medal", stats: types.Stats{Name: name, Total: 1200, Streak: 3}},
+		{name: "comeback", stats: types.Stats{Name: name, Total: 20, Streak: 1}, daysAway: 90, brokenStreak: 12},
+		{
+			name:         "achievement",
+			stats:        types.Stats{Name: name, Total: 20, Streak: 2},
+			achievements: []types.Achievement{{Id: "night-owl", Emoji: ":owl:", Msg: "Night owl: came in after 10pm"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := arrivalBlocks(NewAnnouncementData(types.Arrival{
+				Record: types.AccessRecord{Timestamp: ts, Name: name, AccessGranted: true},
+				Stats:  tt.stats,
+				Door:   tt.door,
+
+				DaysAway:     tt.daysAway,
+				BrokenStreak: tt.brokenStreak,
+				Achievements: earned(tt.stats, tt.achievements...),
+			}))
+			got, err := json.MarshalIndent(slack.Blocks{BlockSet: blocks}, "", "  ")
+			if err != nil {
+				t.Fatalf("error encoding blocks: %s", err)
+			}
+
+			golden := filepath.Join("testdata", "blocks_"+tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatalf("error updating golden file: %s", err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("error reading golden file: %s", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("blocks differ from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	conf := badges.Current()
+	for _, tt := range []struct {
+		total uint
+		want  string
+	}{
+		{total: 1, want: "░░░░░░░░░░"},
+		{total: 4, want: "▓▓▓▓▓░░░░░"},
+		{total: 6, want: "▓▓▓▓▓▓▓▓░░"},
+		{total: 7, want: "░░░░░░░░░░"},
+		{total: 19, want: "▓▓▓▓▓░░░░░"},
+	} {
+		current, _ := conf.Total(tt.total)
+		next, _ := conf.NextTotal(tt.total)
+		got := progressBar(current, next, tt.total)
+		if got != tt.want {
+			t.Errorf("unexpected progress for %d: %s. Wanted %s", tt.total, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package sender
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	DigestDaily   = "day"
+	DigestWeekly  = "week"
+	DigestMonthly = "month"
+
+	// Days before the digest period searched for the start of streaks
+	// broken in it
+	digestLookback = 366
+)
+
+// HistorySource gives access to the stored access records of all members
+type HistorySource interface {
+	History(ctx context.Context, from, to time.Time) ([]types.AccessRecord, error)
+}
+
+// DigestSource gives access to the history, the current stats of members and
+// the achievements awarded to them
+type DigestSource interface {
+	HistorySource
+	Get(ctx context.Context, name string) (types.Stats, error)
+	AwardedBetween(ctx context.Context, from, to time.Time) ([]types.Award, error)
+}
+
+// Digest summarizes what happened between From (inclusive) and To (exclusive)
+type Digest struct {
+	Period        string
+	From          time.Time
+	To            time.Time
+	Visitors      []DigestVisitor
+	Badges        []DigestBadge
+	BrokenStreaks []DigestStreak
+}
+
+type DigestVisitor struct {
+	Name string
+	// Number of different days the member came in during the period
+	Days uint
+	// Stats of the member when the digest was built
+	Stats types.Stats
+}
+
+type DigestBadge struct {
+	Name  string
+	Badge string
+	Msg   string
+}
+
+type DigestStreak struct {
+	Name   string
+	Streak uint
+	Last   time.Time
+}
+
+// DigestRange returns the last full day, week (starting on Monday) or month
+// before now, in the location of now
+func DigestRange(period string, now time.Time) (from, to time.Time, err error) {
+	y, m, d := now.Date()
+	switch period {
+	case DigestDaily:
+		to = time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+		from = to.AddDate(0, 0, -1)
+	case DigestWeekly:
+		sinceMonday := (int(now.Weekday()) + 6) % 7
+		to = time.Date(y, m, d-sinceMonday, 0, 0, 0, 0, now.Location())
+		from = to.AddDate(0, 0, -7)
+	case DigestMonthly:
+		to = time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+		from = to.AddDate(0, -1, 0)
+	default:
+		err = fmt.Errorf("unknown digest period %q", period)
+	}
+	return from, to, err
+}
+
+// BuildDigest lists the badges and achievements awarded in the period, as
+// stored when the arrivals were announced. Broken streaks are found replaying
+// the history from digestLookback days before from, so streaks longer than
+// that are reported with the length they had within it.
+func BuildDigest(ctx context.Context, src DigestSource, period string, from, to time.Time) (Digest, error) {
+	records, err := src.History(ctx, from.AddDate(0, 0, -digestLookback), to)
+	if err != nil {
+		return Digest{}, fmt.Errorf("error getting history: %w", err)
+	}
+	awards, err := src.AwardedBetween(ctx, from, to)
+	if err != nil {
+		return Digest{}, fmt.Errorf("error getting awards: %w", err)
+	}
+
+	d := Digest{Period: period, From: from, To: to}
+	for _, a := range awards {
+		msg := a.Msg
+		if strings.HasPrefix(a.Id, badges.TotalPrefix) {
+			msg = fmt.Sprintf("%s medal", msg)
+		}
+		d.Badges = append(d.Badges, DigestBadge{Name: a.Name, Badge: a.Emoji, Msg: msg})
+	}
+
+	stats := make(map[string]types.Stats)
+	days := make(map[string]uint)
+	inPeriod := func(t time.Time) bool {
+		return !t.Before(from) && t.Before(to)
+	}
+
+	for _, r := range records {
+		if !r.AccessGranted {
+			continue
+		}
+
+		prev, ok := stats[r.Name]
+		if !ok {
+			prev = types.Stats{Name: r.Name}
+		}
+		next := db.BumpStats(prev, r.Timestamp)
+		stats[r.Name] = next
+
+		if prev.Streak > 1 && next.Streak == 1 && inPeriod(dayAfter(prev.Last, from.Location())) {
+			d.BrokenStreaks = append(d.BrokenStreaks, DigestStreak{Name: r.Name, Streak: prev.Streak, Last: prev.Last})
+		}
+
+		if inPeriod(r.Timestamp) && next.Total != prev.Total {
+			days[r.Name]++
+		}
+	}
+
+	// Streaks that ended in the period and haven't been picked up again
+	for _, s := range stats {
+		if s.Streak > 1 && inPeriod(dayAfter(s.Last, from.Location())) {
+			d.BrokenStreaks = append(d.BrokenStreaks, DigestStreak{Name: s.Name, Streak: s.Streak, Last: s.Last})
+		}
+	}
+
+	for name, n := range days {
+		s, err := src.Get(ctx, name)
+		if err != nil {
+			return Digest{}, fmt.Errorf("error getting stats: %w", err)
+		}
+		d.Visitors = append(d.Visitors, DigestVisitor{Name: name, Days: n, Stats: s})
+	}
+	slices.SortFunc(d.Visitors, func(a, b DigestVisitor) int {
+		return cmp.Or(cmp.Compare(b.Days, a.Days), cmp.Compare(a.Name, b.Name))
+	})
+	slices.SortFunc(d.BrokenStreaks, func(a, b DigestStreak) int {
+		return cmp.Or(cmp.Compare(b.Streak, a.Streak), cmp.Compare(a.Name, b.Name))
+	})
+
+	return d, nil
+}
+
+// dayAfter returns the midnight following t in loc, which is the first day
+// missing from a streak whose last visit was t
+func dayAfter(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+}
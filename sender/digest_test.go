@@ -0,0 +1,180 @@
+package sender
+
+import (
+	"context"
+	"log"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	tz = "America/New_York"
+)
+
+type mockHistory []types.AccessRecord
+
+func (h mockHistory) History(_ context.Context, from, to time.Time) ([]types.AccessRecord, error) {
+	var result []types.AccessRecord
+	for _, r := range h {
+		if !r.Timestamp.Before(from) && r.Timestamp.Before(to) {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+type mockDigestSource struct {
+	mockHistory
+	stats  map[string]types.Stats
+	awards []types.Award
+}
+
+func (m mockDigestSource) Get(_ context.Context, name string) (types.Stats, error) {
+	return m.stats[name], nil
+}
+
+func (m mockDigestSource) AwardedBetween(_ context.Context, from, to time.Time) ([]types.Award, error) {
+	var result []types.Award
+	for _, a := range m.awards {
+		if !a.AwardedAt.Before(from) && a.AwardedAt.Before(to) {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func TestDigestRange(t *testing.T) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+
+	// A Wednesday
+	now := time.Date(2025, 1, 15, 8, 0, 0, 0, loc)
+	for _, tt := range []struct {
+		period   string
+		wantFrom time.Time
+		wantTo   time.Time
+	}{
+		{DigestDaily, time.Date(2025, 1, 14, 0, 0, 0, 0, loc), time.Date(2025, 1, 15, 0, 0, 0, 0, loc)},
+		{DigestWeekly, time.Date(2025, 1, 6, 0, 0, 0, 0, loc), time.Date(2025, 1, 13, 0, 0, 0, 0, loc)},
+		{DigestMonthly, time.Date(2024, 12, 1, 0, 0, 0, 0, loc), time.Date(2025, 1, 1, 0, 0, 0, 0, loc)},
+	} {
+		from, to, err := DigestRange(tt.period, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo) {
+			t.Errorf("unexpected range for %s: %s - %s", tt.period, from, to)
+		}
+	}
+
+	if _, _, err := DigestRange("year", now); err == nil {
+		t.Errorf("expected error for unknown period")
+	}
+}
+
+func TestBuildDigest(t *testing.T) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+
+	day := func(d, h int) time.Time {
+		return time.Date(2025, 1, d, h, 0, 0, 0, loc)
+	}
+
+	var history mockHistory
+	// 5 visits in a row, the last one on the digest day: streak badge
+	for d := 13; d <= 17; d++ {
+		history = append(history, types.AccessRecord{Timestamp: day(d, 18), Name: "Streaker", AccessGranted: true})
+	}
+	// 7th visit on the digest day. Second visit the same day doesn't count as
+	// a different day.
+	for d := 1; d <= 11; d += 2 {
+		history = append(history, types.AccessRecord{Timestamp: day(d, 18), Name: "Medalist", AccessGranted: true})
+	}
+	history = append(history,
+		types.AccessRecord{Timestamp: day(17, 10), Name: "Medalist", AccessGranted: true},
+		types.AccessRecord{Timestamp: day(17, 20), Name: "Medalist", AccessGranted: true},
+	)
+	// Streak of 3 ending on the 15th is broken on the 16th: not in the digest
+	// for the 17th
+	for d := 13; d <= 15; d++ {
+		history = append(history, types.AccessRecord{Timestamp: day(d, 9), Name: "Old breaker", AccessGranted: true})
+	}
+	// Streak of 2 ending on the 16th is broken on the 17th
+	history = append(history,
+		types.AccessRecord{Timestamp: day(15, 9), Name: "Breaker", AccessGranted: true},
+		types.AccessRecord{Timestamp: day(16, 9), Name: "Breaker", AccessGranted: true},
+	)
+	// Denied access is ignored
+	history = append(history, types.AccessRecord{Timestamp: day(17, 9), Name: "Denied", AccessGranted: false})
+
+	// A streak broken in the period that started before the look-back window
+	// is reported with the length it had within it
+	history = append(history,
+		types.AccessRecord{Timestamp: day(17, 9).AddDate(0, 0, -digestLookback-2), Name: "Veteran", AccessGranted: true},
+		types.AccessRecord{Timestamp: day(17, 9).AddDate(0, 0, -digestLookback-1), Name: "Veteran", AccessGranted: true},
+	)
+	for d := digestLookback; d >= 1; d-- {
+		history = append(history, types.AccessRecord{Timestamp: day(17, 9).AddDate(0, 0, -d), Name: "Veteran", AccessGranted: true})
+	}
+	slices.SortFunc(history, func(a, b types.AccessRecord) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	src := mockDigestSource{
+		mockHistory: history,
+		stats: map[string]types.Stats{
+			"Medalist": {Name: "Medalist", Total: 7, Streak: 1, Last: day(17, 20)},
+			"Streaker": {Name: "Streaker", Total: 5, Streak: 5, Last: day(17, 18)},
+		},
+		// Badges come from what was awarded in the period, not from the
+		// history
+		awards: []types.Award{
+			{Name: "Streaker", Achievement: types.Achievement{Id: "streak-2", Emoji: ":cat2:", Msg: "Two in a row", AwardedAt: day(14, 18)}},
+			{Name: "Medalist", Achievement: types.Achievement{Id: "total-6", Emoji: ":fatcat-yellow:", Msg: "UNO", AwardedAt: day(17, 10)}},
+			{Name: "Streaker", Achievement: types.Achievement{Id: "streak-4", Emoji: ":black_cat:", Msg: "One dedicated cat!", AwardedAt: day(17, 18)}},
+			{Name: "Medalist", Achievement: types.Achievement{Id: "night-owl", Emoji: ":owl:", Msg: "Night owl: came in after 10pm", AwardedAt: day(17, 23)}},
+		},
+	}
+	got, err := BuildDigest(context.Background(), src, DigestDaily, day(17, 0), day(18, 0))
+	if err != nil {
+		t.Fatalf("error building digest: %s", err)
+	}
+
+	wantVisitors := []DigestVisitor{
+		{Name: "Medalist", Days: 1, Stats: types.Stats{Name: "Medalist", Total: 7, Streak: 1, Last: day(17, 20)}},
+		{Name: "Streaker", Days: 1, Stats: types.Stats{Name: "Streaker", Total: 5, Streak: 5, Last: day(17, 18)}},
+	}
+	if !slices.Equal(got.Visitors, wantVisitors) {
+		log.Printf("want: %+v", wantVisitors)
+		log.Printf("got : %+v", got.Visitors)
+		t.Errorf("visitors differ")
+	}
+
+	wantBadges := []DigestBadge{
+		{Name: "Medalist", Badge: ":fatcat-yellow:", Msg: "UNO medal"},
+		{Name: "Streaker", Badge: ":black_cat:", Msg: "One dedicated cat!"},
+		{Name: "Medalist", Badge: ":owl:", Msg: "Night owl: came in after 10pm"},
+	}
+	if !slices.Equal(got.Badges, wantBadges) {
+		log.Printf("want: %+v", wantBadges)
+		log.Printf("got : %+v", got.Badges)
+		t.Errorf("badges differ")
+	}
+
+	wantBroken := []DigestStreak{
+		{Name: "Veteran", Streak: digestLookback, Last: day(16, 9)},
+		{Name: "Breaker", Streak: 2, Last: day(16, 9)},
+	}
+	if !slices.Equal(got.BrokenStreaks, wantBroken) {
+		log.Printf("want: %+v", wantBroken)
+		log.Printf("got : %+v", got.BrokenStreaks)
+		t.Errorf("broken streaks differ")
+	}
+}
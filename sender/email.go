@@ -0,0 +1,150 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	//go:embed templates/digest.*.tmpl
+	digestTemplates embed.FS
+
+	digestText = template.Must(template.ParseFS(digestTemplates, "templates/digest.txt.tmpl"))
+	digestHtml = htmltemplate.Must(htmltemplate.ParseFS(digestTemplates, "templates/digest.html.tmpl"))
+)
+
+type EmailConfig struct {
+	// host:port of the SMTP server. STARTTLS is used when the server
+	// supports it.
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// EmailSender mails digests over SMTP
+type EmailSender struct {
+	conf     EmailConfig
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+type digestData struct {
+	Subject string
+	Digest
+}
+
+func NewEmail(conf EmailConfig) *EmailSender {
+	return &EmailSender{conf: conf, sendMail: smtp.SendMail}
+}
+
+func (s *EmailSender) SendDigest(ctx context.Context, d Digest) error {
+	data := digestData{Subject: digestSubject(d), Digest: d}
+
+	var text, html strings.Builder
+	if err := digestText.Execute(&text, data); err != nil {
+		return fmt.Errorf("error rendering text digest: %w", err)
+	}
+	if err := digestHtml.Execute(&html, data); err != nil {
+		return fmt.Errorf("error rendering html digest: %w", err)
+	}
+
+	return s.Send(ctx, data.Subject, text.String(), html.String())
+}
+
+// Send mails a multipart/alternative message with both a text and an html
+// version of the body
+func (s *EmailSender) Send(ctx context.Context, subject, text, html string) error {
+	msg, err := s.buildMessage(subject, text, html)
+	if err != nil {
+		return fmt.Errorf("error building email: %w", err)
+	}
+
+	var auth smtp.Auth
+	if s.conf.Username != "" {
+		host, _, err := net.SplitHostPort(s.conf.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address %q: %w", s.conf.Addr, err)
+		}
+		auth = smtp.PlainAuth("", s.conf.Username, s.conf.Password, host)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.sendMail(s.conf.Addr, auth, s.conf.From, s.conf.To, msg); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Email sent", "subject", subject, "to", strings.Join(s.conf.To, ", "))
+	return nil
+}
+
+func (s *EmailSender) buildMessage(subject, text, html string) ([]byte, error) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+
+	fmt.Fprintf(&b, "From: %s\r\n", s.conf.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.conf.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func digestSubject(d Digest) string {
+	const day = "Mon Jan 2"
+	switch d.Period {
+	case DigestWeekly:
+		return fmt.Sprintf(
+			"Doorbot2 weekly digest: %s - %s",
+			d.From.Format(day),
+			d.To.AddDate(0, 0, -1).Format(day),
+		)
+	case DigestMonthly:
+		return fmt.Sprintf("Doorbot2 monthly digest: %s", d.From.Format("January 2006"))
+	default:
+		return fmt.Sprintf("Doorbot2 daily digest: %s", d.From.Format(day))
+	}
+}
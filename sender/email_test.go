@@ -0,0 +1,129 @@
+package sender
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts a single message, sending it over the returned channel
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+
+	msgs := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "DATA"):
+				reply("354 go ahead")
+				var sb strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					sb.WriteString(l)
+				}
+				msgs <- sb.String()
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return l.Addr().String(), msgs
+}
+
+func TestSendDigest(t *testing.T) {
+	addr, msgs := fakeSMTP(t)
+	s := NewEmail(EmailConfig{
+		Addr: addr,
+		From: "doorbot2@example.org",
+		To:   []string{"board@example.org"},
+	})
+
+	from := time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)
+	d := Digest{
+		Period:   DigestDaily,
+		From:     from,
+		To:       from.AddDate(0, 0, 1),
+		Visitors: []DigestVisitor{{Name: "Johnny <Melavo>", Days: 1}},
+		Badges:   []DigestBadge{{Name: "Johnny <Melavo>", Msg: "UNO medal"}},
+	}
+	if err := s.SendDigest(context.Background(), d); err != nil {
+		t.Fatalf("error sending digest: %s", err)
+	}
+
+	var raw string
+	select {
+	case raw = <-msgs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("error parsing message: %s", err)
+	}
+	if got := msg.Header.Get("Subject"); got != "Doorbot2 daily digest: Fri Jan 17" {
+		t.Errorf("unexpected subject %q", got)
+	}
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("error parsing content type: %s", err)
+	}
+
+	parts := make(map[string]string)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("error reading part: %s", err)
+		}
+		body, _ := io.ReadAll(p)
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[ct] = string(body)
+	}
+
+	if text := parts["text/plain"]; !strings.Contains(text, "Johnny <Melavo>: 1 day(s)") ||
+		!strings.Contains(text, "Johnny <Melavo>: UNO medal") {
+		t.Errorf("unexpected text part: %s", text)
+	}
+	if html := parts["text/html"]; !strings.Contains(html, "Johnny &lt;Melavo&gt;") {
+		t.Errorf("names should be escaped in the html part: %s", html)
+	}
+}
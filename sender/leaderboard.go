@@ -0,0 +1,168 @@
+package sender
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/badges"
+	"github.com/fatcatfablab/doorbot2/db"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	leaderboardSize = 5
+)
+
+// LeaderboardPoster is implemented by senders that can post leaderboards
+type LeaderboardPoster interface {
+	PostLeaderboard(ctx context.Context, l Leaderboard) error
+}
+
+// LeaderboardSource gives access to the history, and to the members who
+// opted out of announcements, who are left out of leaderboards
+type LeaderboardSource interface {
+	HistorySource
+	OptedOut(ctx context.Context) (map[string]bool, error)
+}
+
+// Leaderboard ranks members between From (inclusive) and To (exclusive)
+type Leaderboard struct {
+	Period string
+	From   time.Time
+	To     time.Time
+	// Members who came in on the most days
+	Visits []LeaderboardEntry
+	// Longest streaks still going at To
+	Streaks []LeaderboardEntry
+	// Members whose first visit ever was in the period
+	Newcomers []string
+}
+
+type LeaderboardEntry struct {
+	Name  string
+	Value uint
+	Badge badges.Tier
+}
+
+// LeaderboardRange returns the period containing now so far if current is
+// set, or the last full one otherwise
+func LeaderboardRange(period string, now time.Time, current bool) (from, to time.Time, err error) {
+	from, to, err = DigestRange(period, now)
+	if err != nil || !current {
+		return from, to, err
+	}
+	return to, now, nil
+}
+
+// BuildLeaderboard replays the whole history up to to, so streaks are the
+// same that were announced. Members who never want their arrivals announced
+// aren't ranked.
+func BuildLeaderboard(ctx context.Context, src LeaderboardSource, period string, from, to time.Time) (Leaderboard, error) {
+	records, err := src.History(ctx, time.Unix(0, 0), to)
+	if err != nil {
+		return Leaderboard{}, fmt.Errorf("error getting history: %w", err)
+	}
+	optedOut, err := src.OptedOut(ctx)
+	if err != nil {
+		return Leaderboard{}, fmt.Errorf("error getting preferences: %w", err)
+	}
+
+	l := Leaderboard{Period: period, From: from, To: to}
+	stats := make(map[string]types.Stats)
+	days := make(map[string]uint)
+	for _, r := range records {
+		if !r.AccessGranted || optedOut[r.Name] {
+			continue
+		}
+
+		prev, ok := stats[r.Name]
+		if !ok {
+			prev = types.Stats{Name: r.Name}
+		}
+		next := db.BumpStats(prev, r.Timestamp)
+		stats[r.Name] = next
+
+		if r.Timestamp.Before(from) || next.Total == prev.Total {
+			continue
+		}
+		days[r.Name]++
+		if next.Total == 1 {
+			l.Newcomers = append(l.Newcomers, r.Name)
+		}
+	}
+
+	conf := badges.Current()
+	for name, n := range days {
+		tier, _ := conf.Total(stats[name].Total)
+		l.Visits = append(l.Visits, LeaderboardEntry{Name: name, Value: n, Badge: tier})
+	}
+
+	// A streak is still going if it can be continued on the day to is in
+	today := startOfDay(to)
+	for _, s := range stats {
+		if s.Streak > 1 && !dayAfter(s.Last, to.Location()).Before(today) {
+			tier, _ := conf.Streak(s.Streak)
+			l.Streaks = append(l.Streaks, LeaderboardEntry{Name: s.Name, Value: s.Streak, Badge: tier})
+		}
+	}
+
+	l.Visits = topEntries(l.Visits)
+	l.Streaks = topEntries(l.Streaks)
+	return l, nil
+}
+
+// Text formats the leaderboard as Slack mrkdwn
+func (l Leaderboard) Text() string {
+	var sb strings.Builder
+	if l.Period == DigestMonthly {
+		fmt.Fprintf(&sb, ":trophy: *Leaderboard for %s*", l.From.Format("January 2006"))
+	} else {
+		fmt.Fprintf(&sb, ":trophy: *Leaderboard for the %s of %s*", l.Period, l.From.Format("Mon Jan 2"))
+	}
+
+	if len(l.Visits) == 0 {
+		sb.WriteString("\nNobody came in :crying_cat_face:")
+		return sb.String()
+	}
+
+	sb.WriteString("\n\n*Most visits*")
+	for i, e := range l.Visits {
+		fmt.Fprintf(&sb, "\n%d. %s %s %d %s", i+1, e.Name, e.Badge.Emoji, e.Value, plural(e.Value, "day"))
+	}
+
+	if len(l.Streaks) > 0 {
+		sb.WriteString("\n\n*Longest active streaks*")
+		for i, e := range l.Streaks {
+			fmt.Fprintf(&sb, "\n%d. %s %s %d %s", i+1, e.Name, e.Badge.Emoji, e.Value, plural(e.Value, "day"))
+		}
+	}
+
+	if len(l.Newcomers) > 0 {
+		fmt.Fprintf(&sb, "\n\n*Newcomers* :wave:\n%s", strings.Join(l.Newcomers, ", "))
+	}
+
+	return sb.String()
+}
+
+func topEntries(entries []LeaderboardEntry) []LeaderboardEntry {
+	slices.SortFunc(entries, func(a, b LeaderboardEntry) int {
+		return cmp.Or(cmp.Compare(b.Value, a.Value), cmp.Compare(a.Name, b.Name))
+	})
+	return entries[:min(len(entries), leaderboardSize)]
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func plural(n uint, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
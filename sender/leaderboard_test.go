@@ -0,0 +1,129 @@
+package sender
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+func TestLeaderboardRange(t *testing.T) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+
+	// A Wednesday
+	now := time.Date(2025, 1, 15, 8, 0, 0, 0, loc)
+	for _, tt := range []struct {
+		period   string
+		current  bool
+		wantFrom time.Time
+		wantTo   time.Time
+	}{
+		{DigestWeekly, false, time.Date(2025, 1, 6, 0, 0, 0, 0, loc), time.Date(2025, 1, 13, 0, 0, 0, 0, loc)},
+		{DigestWeekly, true, time.Date(2025, 1, 13, 0, 0, 0, 0, loc), now},
+		{DigestMonthly, false, time.Date(2024, 12, 1, 0, 0, 0, 0, loc), time.Date(2025, 1, 1, 0, 0, 0, 0, loc)},
+		{DigestMonthly, true, time.Date(2025, 1, 1, 0, 0, 0, 0, loc), now},
+	} {
+		from, to, err := LeaderboardRange(tt.period, now, tt.current)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo) {
+			t.Errorf("unexpected range for %s (current: %t): %s - %s", tt.period, tt.current, from, to)
+		}
+	}
+}
+
+type mockLeaderboardSource struct {
+	mockHistory
+	optedOut map[string]bool
+}
+
+func (m mockLeaderboardSource) OptedOut(_ context.Context) (map[string]bool, error) {
+	return m.optedOut, nil
+}
+
+func TestBuildLeaderboard(t *testing.T) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+
+	day := func(d, h int) time.Time {
+		return time.Date(2025, 1, d, h, 0, 0, 0, loc)
+	}
+
+	var history mockHistory
+	add := func(name string, days ...int) {
+		for _, d := range days {
+			history = append(history, types.AccessRecord{Timestamp: day(d, 18), Name: name, AccessGranted: true})
+		}
+	}
+	// The week of Jan 6 - 12
+	add("Regular", 1, 2, 3, 6, 7, 8, 9, 10, 11, 12)
+	add("Newcomer", 11, 12)
+	// Streak broken before the end of the week
+	add("Broken", 3, 6, 7)
+	// Two visits the same day count once
+	add("Twice", 5, 6, 6)
+	add("Denied")
+	// Would top every list, but never wants to be announced
+	add("Shy", 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12)
+	history = append(history, types.AccessRecord{Timestamp: day(8, 9), Name: "Denied", AccessGranted: false})
+	slices.SortFunc(history, func(a, b types.AccessRecord) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+
+	src := mockLeaderboardSource{mockHistory: history, optedOut: map[string]bool{"Shy": true}}
+	got, err := BuildLeaderboard(context.Background(), src, DigestWeekly, day(6, 0), day(13, 0))
+	if err != nil {
+		t.Fatalf("error building leaderboard: %s", err)
+	}
+
+	names := func(entries []LeaderboardEntry) []string {
+		var result []string
+		for _, e := range entries {
+			result = append(result, e.Name)
+		}
+		return result
+	}
+
+	if want := []string{"Regular", "Broken", "Newcomer", "Twice"}; !slices.Equal(names(got.Visits), want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %+v", got.Visits)
+		t.Errorf("visits differ")
+	}
+	if got.Visits[0].Value != 7 || got.Visits[3].Value != 1 {
+		t.Errorf("unexpected visit counts: %+v", got.Visits)
+	}
+
+	if want := []string{"Regular", "Newcomer"}; !slices.Equal(names(got.Streaks), want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %+v", got.Streaks)
+		t.Errorf("streaks differ")
+	}
+
+	if want := []string{"Newcomer"}; !slices.Equal(got.Newcomers, want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %v", got.Newcomers)
+		t.Errorf("newcomers differ")
+	}
+
+	text := got.Text()
+	for _, want := range []string{
+		"*Leaderboard for the week of Mon Jan 6*",
+		"1. Regular :fatcat-yellow: 7 days",
+		"*Longest active streaks*\n1. Regular",
+		"*Newcomers* :wave:\nNewcomer",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("%q not found in %q", want, text)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	mqttTimeout = 10 * time.Second
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+	// Messages waiting to be published. Messages coming in while it's full
+	// are dropped.
+	mqttQueueSize = 100
+)
+
+var topicReplacer = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+// Presence reports who is in the lab right now
+type Presence interface {
+	Here(ctx context.Context) ([]types.AccessRecord, error)
+	OptedOut(ctx context.Context) (map[string]bool, error)
+}
+
+type MQTTConfig struct {
+	Broker   string
+	ClientId string
+	Username string
+	Password string
+	Prefix   string
+	QoS      byte
+
+	// TLS settings. They're only used with ssl://, tls:// or mqtts:// brokers
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Insecure bool
+}
+
+// MQTTSender publishes every arrival to <prefix>/arrival/<member>, and keeps
+// retained copies of the member stats in <prefix>/stats/<member> and of the
+// current occupancy in <prefix>/occupancy. <prefix>/status holds "online"
+// while connected and is set to "offline" by the broker through the last will
+// if the connection drops.
+//
+// Messages are published in the background, so a slow broker doesn't hold
+// back the caller. Close waits for the queued ones.
+type MQTTSender struct {
+	client   mqtt.Client
+	prefix   string
+	qos      byte
+	presence Presence
+
+	// Guards closing queue, so messages coming in while closing are dropped
+	mu     sync.Mutex
+	closed bool
+	queue  chan mqttMessage
+	done   chan struct{}
+}
+
+type mqttMessage struct {
+	ctx      context.Context
+	topic    string
+	retained bool
+	payload  []byte
+}
+
+// occupancy counts everyone who came in today, but only lists the members who
+// haven't opted out of announcements
+type occupancy struct {
+	Count   int                  `json:"count"`
+	Members []types.AccessRecord `json:"members"`
+	Updated time.Time            `json:"updated"`
+}
+
+func NewMQTT(conf MQTTConfig, presence Presence) (*MQTTSender, error) {
+	s := &MQTTSender{
+		prefix:   conf.Prefix,
+		qos:      conf.QoS,
+		presence: presence,
+		queue:    make(chan mqttMessage, mqttQueueSize),
+		done:     make(chan struct{}),
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(conf.Broker).
+		SetClientID(conf.ClientId).
+		SetUsername(conf.Username).
+		SetPassword(conf.Password).
+		SetConnectTimeout(mqttTimeout).
+		SetAutoReconnect(true).
+		SetBinaryWill(s.topic("status"), []byte(mqttOffline), conf.QoS, true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			slog.Info("Connected to mqtt broker", "broker", conf.Broker)
+			t := c.Publish(s.topic("status"), s.qos, true, mqttOnline)
+			if t.WaitTimeout(mqttTimeout) && t.Error() != nil {
+				slog.Error("error publishing mqtt status", "err", t.Error())
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("lost connection to mqtt broker", "err", err)
+		})
+
+	if conf.CAFile != "" || conf.CertFile != "" || conf.Insecure {
+		tlsConf, err := mqttTLSConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConf)
+	}
+
+	s.client = mqtt.NewClient(opts)
+	if err := wait(s.client.Connect()); err != nil {
+		return nil, fmt.Errorf("error connecting to mqtt broker: %w", err)
+	}
+	go s.run()
+
+	return s, nil
+}
+
+func mqttTLSConfig(conf MQTTConfig) (*tls.Config, error) {
+	tlsConf := &tls.Config{InsecureSkipVerify: conf.Insecure}
+
+	if conf.CAFile != "" {
+		ca, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading mqtt ca file: %w", err)
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %q", conf.CAFile)
+		}
+	}
+
+	if conf.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading mqtt client certificate: %w", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConf, nil
+}
+
+func (s *MQTTSender) Post(ctx context.Context, a types.Arrival) error {
+	member := topicReplacer.Replace(a.Stats.Name)
+
+	errs := []error{
+		s.publish(ctx, s.topic("arrival", member), false, a),
+		s.publish(ctx, s.topic("stats", member), true, a.Stats),
+		s.PublishOccupancy(ctx),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "Arrival queued for mqtt", "prefix", s.prefix)
+	return nil
+}
+
+// PublishOccupancy updates the retained occupancy. Besides on every arrival,
+// it's run at midnight, when the members who came in the day before stop
+// counting.
+func (s *MQTTSender) PublishOccupancy(ctx context.Context) error {
+	if s.presence == nil {
+		return nil
+	}
+
+	here, err := s.presence.Here(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting occupancy: %w", err)
+	}
+	optedOut, err := s.presence.OptedOut(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting occupancy: %w", err)
+	}
+
+	o := occupancy{Count: len(here), Members: make([]types.AccessRecord, 0, len(here)), Updated: time.Now()}
+	for _, r := range here {
+		if !optedOut[r.Name] {
+			o.Members = append(o.Members, r)
+		}
+	}
+	return s.publish(ctx, s.topic("occupancy"), true, o)
+}
+
+// Close publishes the queued messages, marks doorbot2 as offline and
+// disconnects from the broker. The last will isn't sent by the broker on
+// clean disconnections.
+func (s *MQTTSender) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+	<-s.done
+
+	if err := wait(s.client.Publish(s.topic("status"), s.qos, true, mqttOffline)); err != nil {
+		slog.Error("error publishing mqtt status", "err", err)
+	}
+	s.client.Disconnect(uint(mqttTimeout.Milliseconds()))
+}
+
+// publish queues a message. Publishing errors are only logged.
+func (s *MQTTSender) publish(ctx context.Context, topic string, retained bool, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding payload for %s: %w", topic, err)
+	}
+
+	m := mqttMessage{ctx: context.WithoutCancel(ctx), topic: topic, retained: retained, payload: payload}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("mqtt sender is closed, dropping message for %s", topic)
+	}
+	select {
+	case s.queue <- m:
+		return nil
+	default:
+		return fmt.Errorf("mqtt queue is full, dropping message for %s", topic)
+	}
+}
+
+func (s *MQTTSender) run() {
+	defer close(s.done)
+	for m := range s.queue {
+		if err := wait(s.client.Publish(m.topic, s.qos, m.retained, m.payload)); err != nil {
+			slog.ErrorContext(m.ctx, "error publishing to mqtt", "topic", m.topic, "err", err)
+		}
+	}
+}
+
+func (s *MQTTSender) topic(levels ...string) string {
+	return strings.Join(append([]string{s.prefix}, levels...), "/")
+}
+
+func wait(t mqtt.Token) error {
+	if !t.WaitTimeout(mqttTimeout) {
+		return errors.New("timed out waiting for the broker")
+	}
+	return t.Error()
+}
@@ -0,0 +1,130 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+type mockPresence struct {
+	here     []types.AccessRecord
+	optedOut map[string]bool
+}
+
+func (p mockPresence) Here(_ context.Context) ([]types.AccessRecord, error) {
+	return p.here, nil
+}
+
+func (p mockPresence) OptedOut(_ context.Context) (map[string]bool, error) {
+	return p.optedOut, nil
+}
+
+func startBroker(t *testing.T) (*mochi.Server, string) {
+	broker := mochi.New(&mochi.Options{InlineClient: true})
+	if err := broker.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatalf("error adding auth hook: %s", err)
+	}
+
+	tcp := listeners.NewTCP(listeners.Config{ID: "t1", Address: "127.0.0.1:0"})
+	if err := broker.AddListener(tcp); err != nil {
+		t.Fatalf("error adding listener: %s", err)
+	}
+	if err := broker.Serve(); err != nil {
+		t.Fatalf("error starting broker: %s", err)
+	}
+
+	return broker, "tcp://" + tcp.Address()
+}
+
+func TestMQTTPost(t *testing.T) {
+	broker, addr := startBroker(t)
+	defer broker.Close()
+
+	var mu sync.Mutex
+	received := make(map[string][]byte)
+	err := broker.Subscribe("doorbot2/#", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[pk.TopicName] = pk.Payload
+	})
+	if err != nil {
+		t.Fatalf("error subscribing: %s", err)
+	}
+
+	a := testArrival()
+	a.Stats.Name = "Johnny/Melavo"
+	shy := types.AccessRecord{Timestamp: a.Record.Timestamp, Name: "Shy Member", AccessGranted: true}
+	presence := mockPresence{
+		here:     []types.AccessRecord{a.Record, shy},
+		optedOut: map[string]bool{shy.Name: true},
+	}
+	s, err := NewMQTT(MQTTConfig{Broker: addr, ClientId: "doorbot2-test", Prefix: "doorbot2", QoS: 1}, presence)
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	if err := s.Post(context.Background(), a); err != nil {
+		t.Fatalf("error posting: %s", err)
+	}
+
+	wantTopics := []string{
+		"doorbot2/status",
+		"doorbot2/arrival/Johnny_Melavo",
+		"doorbot2/stats/Johnny_Melavo",
+		"doorbot2/occupancy",
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, topic := range wantTopics {
+		for {
+			mu.Lock()
+			_, ok := received[topic]
+			mu.Unlock()
+			if ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("nothing received on %s", topic)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	var got occupancy
+	mu.Lock()
+	err = json.Unmarshal(received["doorbot2/occupancy"], &got)
+	mu.Unlock()
+	if err != nil {
+		t.Fatalf("error decoding occupancy: %s", err)
+	}
+	if got.Count != 2 || len(got.Members) != 1 || got.Members[0].Name != a.Record.Name {
+		t.Errorf("unexpected occupancy: %+v", got)
+	}
+
+	for _, topic := range []string{"doorbot2/stats/Johnny_Melavo", "doorbot2/occupancy"} {
+		if len(broker.Topics.Messages(topic)) != 1 {
+			t.Errorf("%s should be retained", topic)
+		}
+	}
+	if len(broker.Topics.Messages("doorbot2/arrival/Johnny_Melavo")) != 0 {
+		t.Errorf("arrivals should not be retained")
+	}
+
+	s.Close()
+	retained := broker.Topics.Messages("doorbot2/status")
+	if len(retained) != 1 || string(retained[0].Payload) != mqttOffline {
+		t.Errorf("status should be %q after closing", mqttOffline)
+	}
+
+	// Arrivals coming in while shutting down are dropped
+	if err := s.Post(context.Background(), a); err == nil {
+		t.Errorf("expected an error after closing")
+	}
+	s.Close()
+}
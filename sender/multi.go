@@ -0,0 +1,35 @@
+package sender
+
+import (
+	"context"
+	"errors"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+// Multi fans out every arrival to all of its senders. A failing sender
+// doesn't prevent the rest from being called.
+type Multi []types.Sender
+
+func (m Multi) Post(ctx context.Context, a types.Arrival) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Post(ctx, a); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PostLeaderboard posts the leaderboard to the senders that support it
+func (m Multi) PostLeaderboard(ctx context.Context, l Leaderboard) error {
+	var errs []error
+	for _, s := range m {
+		if p, ok := s.(LeaderboardPoster); ok {
+			if err := p.PostLeaderboard(ctx, l); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
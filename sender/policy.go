@@ -0,0 +1,291 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	// QuietSuppress drops arrivals during quiet hours
+	QuietSuppress = "suppress"
+	// QuietDefer holds arrivals during quiet hours and announces them all
+	// together when quiet hours end
+	QuietDefer = "defer"
+
+	policyTimeout = 30 * time.Second
+)
+
+// Summary is a set of arrivals announced in a single message
+type Summary struct {
+	Arrivals []types.Arrival
+	// Whether the arrivals were held back during quiet hours
+	Deferred bool
+}
+
+// Summarizer is a Sender that can also announce several arrivals at once
+type Summarizer interface {
+	types.Sender
+	PostSummary(ctx context.Context, s Summary) error
+}
+
+type PolicyConfig struct {
+	// Quiet hours, as the time since midnight they start and end at. They
+	// span midnight when QuietStart is after QuietEnd, and are disabled when
+	// both are the same.
+	QuietStart time.Duration
+	QuietEnd   time.Duration
+	// QuietSuppress or QuietDefer
+	QuietMode string
+	// Arrivals coming less than BatchWindow after an announcement are
+	// collapsed into a single message, posted when the window ends. Zero
+	// disables batching.
+	BatchWindow time.Duration
+}
+
+// Outbox keeps the arrivals deferred during quiet hours, so they're still
+// announced after a restart
+type Outbox interface {
+	DeferArrival(ctx context.Context, a types.Arrival) error
+	DeferredArrivals(ctx context.Context) ([]types.Arrival, error)
+	ClearDeferredArrivals(ctx context.Context) error
+}
+
+// Policy sits in front of a Summarizer deciding when arrivals are announced
+type Policy struct {
+	sender Summarizer
+	conf   PolicyConfig
+	loc    *time.Location
+	outbox Outbox
+	now    func() time.Time
+
+	mu         sync.Mutex
+	windowEnd  time.Time
+	batch      []types.Arrival
+	batchTimer *time.Timer
+	deferred   []types.Arrival
+	quietTimer *time.Timer
+}
+
+// NewPolicy returns a policy deferring arrivals to outbox. Without one,
+// deferred arrivals are lost on restart.
+func NewPolicy(sender Summarizer, conf PolicyConfig, loc *time.Location, outbox Outbox) *Policy {
+	return &Policy{sender: sender, conf: conf, loc: loc, outbox: outbox, now: time.Now}
+}
+
+// Restore picks up the arrivals deferred before a restart. They're announced
+// when quiet hours end, or right away if they already did.
+func (p *Policy) Restore(ctx context.Context) error {
+	if p.outbox == nil {
+		return nil
+	}
+	deferred, err := p.outbox.DeferredArrivals(ctx)
+	if err != nil {
+		return fmt.Errorf("error restoring deferred arrivals: %w", err)
+	}
+	if len(deferred) == 0 {
+		return nil
+	}
+
+	now := p.now().In(p.loc)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deferred = append(deferred, p.deferred...)
+	if p.quietTimer == nil {
+		var wait time.Duration
+		if p.quiet(now) {
+			wait = p.quietEnd(now).Sub(now)
+		}
+		p.quietTimer = time.AfterFunc(wait, p.flushDeferred)
+	}
+	slog.InfoContext(ctx, "Restored deferred announcements", "count", len(deferred))
+	return nil
+}
+
+// ParseQuietHours parses quiet hours like "22:00-07:00"
+func ParseQuietHours(s string) (start, end time.Duration, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid quiet hours %q: want HH:MM-HH:MM", s)
+	}
+	for _, v := range []struct {
+		s string
+		d *time.Duration
+	}{{from, &start}, {to, &end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(v.s))
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid quiet hours %q: %w", s, err)
+		}
+		*v.d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return start, end, nil
+}
+
+func (p *Policy) Post(ctx context.Context, a types.Arrival) error {
+	now := p.now().In(p.loc)
+
+	p.mu.Lock()
+	if p.quiet(now) {
+		defer p.mu.Unlock()
+		if p.conf.QuietMode != QuietDefer {
+			slog.InfoContext(ctx, "Quiet hours, not announcing", "member", a.Stats.Name)
+			return nil
+		}
+		slog.InfoContext(ctx, "Quiet hours, deferring announcement", "member", a.Stats.Name)
+		p.deferred = append(p.deferred, a)
+		if p.quietTimer == nil {
+			p.quietTimer = time.AfterFunc(p.quietEnd(now).Sub(now), p.flushDeferred)
+		}
+		if p.outbox != nil {
+			if err := p.outbox.DeferArrival(ctx, a); err != nil {
+				return fmt.Errorf("error storing deferred arrival, it won't survive a restart: %w", err)
+			}
+		}
+		return nil
+	}
+
+	if p.conf.BatchWindow > 0 {
+		if now.Before(p.windowEnd) {
+			slog.InfoContext(ctx, "Batching announcement", "member", a.Stats.Name)
+			p.batch = append(p.batch, a)
+			p.mu.Unlock()
+			return nil
+		}
+		p.openWindow(now)
+	}
+	p.mu.Unlock()
+
+	return p.sender.Post(ctx, a)
+}
+
+// PostLeaderboard hands the leaderboard over right away, if the sender
+// supports it. Leaderboards are scheduled, so they're never held back.
+func (p *Policy) PostLeaderboard(ctx context.Context, l Leaderboard) error {
+	if lp, ok := p.sender.(LeaderboardPoster); ok {
+		return lp.PostLeaderboard(ctx, l)
+	}
+	return nil
+}
+
+// Pending returns how many arrivals are waiting to be announced
+func (p *Policy) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.batch) + len(p.deferred)
+}
+
+// Close announces the batched arrivals right away. Arrivals deferred until
+// the end of quiet hours are left in the outbox for the next start, or
+// dropped without one.
+func (p *Policy) Close() {
+	p.mu.Lock()
+	if p.batchTimer != nil {
+		p.batchTimer.Stop()
+	}
+	if p.quietTimer != nil {
+		p.quietTimer.Stop()
+	}
+	if len(p.deferred) > 0 {
+		if p.outbox != nil {
+			slog.Info("Keeping deferred announcements for the next start", "count", len(p.deferred))
+		} else {
+			slog.Warn("Dropping deferred announcements", "count", len(p.deferred))
+		}
+	}
+	batch := p.batch
+	p.batch, p.deferred = nil, nil
+	p.mu.Unlock()
+
+	p.send(Summary{Arrivals: batch})
+}
+
+func (p *Policy) quiet(now time.Time) bool {
+	start, end := p.conf.QuietStart, p.conf.QuietEnd
+	sinceMidnight := time.Duration(now.Hour())*time.Hour +
+		time.Duration(now.Minute())*time.Minute +
+		time.Duration(now.Second())*time.Second
+
+	switch {
+	case start == end:
+		return false
+	case start < end:
+		return sinceMidnight >= start && sinceMidnight < end
+	default:
+		return sinceMidnight >= start || sinceMidnight < end
+	}
+}
+
+// quietEnd returns when the quiet hours now is in end
+func (p *Policy) quietEnd(now time.Time) time.Time {
+	y, m, d := now.Date()
+	h, min := int(p.conf.QuietEnd/time.Hour), int(p.conf.QuietEnd%time.Hour/time.Minute)
+	end := time.Date(y, m, d, h, min, 0, 0, now.Location())
+	if !end.After(now) {
+		end = time.Date(y, m, d+1, h, min, 0, 0, now.Location())
+	}
+	return end
+}
+
+// openWindow starts a batching window. Must be called with the lock held.
+func (p *Policy) openWindow(now time.Time) {
+	p.windowEnd = now.Add(p.conf.BatchWindow)
+	p.batchTimer = time.AfterFunc(p.conf.BatchWindow, p.flushBatch)
+}
+
+// flushBatch announces the arrivals batched during the window that just
+// ended. Announcing them opens a new window.
+func (p *Policy) flushBatch() {
+	p.mu.Lock()
+	batch := p.batch
+	p.batch = nil
+	if len(batch) > 0 {
+		p.openWindow(p.now())
+	} else {
+		p.windowEnd = time.Time{}
+		p.batchTimer = nil
+	}
+	p.mu.Unlock()
+
+	p.send(Summary{Arrivals: batch})
+}
+
+func (p *Policy) flushDeferred() {
+	p.mu.Lock()
+	deferred := p.deferred
+	p.deferred = nil
+	p.quietTimer = nil
+	p.mu.Unlock()
+
+	p.send(Summary{Arrivals: deferred, Deferred: true})
+	if p.outbox != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), policyTimeout)
+		defer cancel()
+		if err := p.outbox.ClearDeferredArrivals(ctx); err != nil {
+			slog.ErrorContext(ctx, "error clearing deferred arrivals", "err", err)
+		}
+	}
+}
+
+func (p *Policy) send(s Summary) {
+	if len(s.Arrivals) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyTimeout)
+	defer cancel()
+
+	var err error
+	if len(s.Arrivals) == 1 && !s.Deferred {
+		err = p.sender.Post(ctx, s.Arrivals[0])
+	} else {
+		err = p.sender.PostSummary(ctx, s)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error posting batched arrivals", "count", len(s.Arrivals), "err", err)
+	}
+}
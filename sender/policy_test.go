@@ -0,0 +1,305 @@
+package sender
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+type mockSummarizer struct {
+	sync.Mutex
+	posted    []string
+	summaries []Summary
+}
+
+func (m *mockSummarizer) Post(_ context.Context, a types.Arrival) error {
+	m.Lock()
+	defer m.Unlock()
+	m.posted = append(m.posted, a.Stats.Name)
+	return nil
+}
+
+func (m *mockSummarizer) PostSummary(_ context.Context, s Summary) error {
+	m.Lock()
+	defer m.Unlock()
+	m.summaries = append(m.summaries, s)
+	return nil
+}
+
+type mockOutbox struct {
+	sync.Mutex
+	arrivals []types.Arrival
+}
+
+func (m *mockOutbox) DeferArrival(_ context.Context, a types.Arrival) error {
+	m.Lock()
+	defer m.Unlock()
+	m.arrivals = append(m.arrivals, a)
+	return nil
+}
+
+func (m *mockOutbox) DeferredArrivals(_ context.Context) ([]types.Arrival, error) {
+	m.Lock()
+	defer m.Unlock()
+	return slices.Clone(m.arrivals), nil
+}
+
+func (m *mockOutbox) ClearDeferredArrivals(_ context.Context) error {
+	m.Lock()
+	defer m.Unlock()
+	m.arrivals = nil
+	return nil
+}
+
+func arrivalOf(name string) types.Arrival {
+	return types.Arrival{Stats: types.Stats{Name: name, Total: 2, Streak: 1}}
+}
+
+func summaryNames(s Summary) []string {
+	var names []string
+	for _, a := range s.Arrivals {
+		names = append(names, a.Stats.Name)
+	}
+	return names
+}
+
+func TestParseQuietHours(t *testing.T) {
+	start, end, err := ParseQuietHours("22:30-07:00")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if start != 22*time.Hour+30*time.Minute || end != 7*time.Hour {
+		t.Errorf("unexpected quiet hours %s - %s", start, end)
+	}
+
+	for _, s := range []string{"22:00", "22:00-7", "25:00-07:00"} {
+		if _, _, err := ParseQuietHours(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestPolicyQuietHours(t *testing.T) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+	ctx := context.Background()
+
+	for _, mode := range []string{QuietSuppress, QuietDefer} {
+		t.Run(mode, func(t *testing.T) {
+			m := &mockSummarizer{}
+			p := NewPolicy(m, PolicyConfig{QuietStart: 22 * time.Hour, QuietEnd: 7 * time.Hour, QuietMode: mode}, loc, nil)
+			defer p.Close()
+
+			for _, tt := range []struct {
+				name string
+				now  time.Time
+			}{
+				{"Evening", time.Date(2025, 1, 20, 21, 59, 0, 0, loc)},
+				{"Late night", time.Date(2025, 1, 20, 23, 0, 0, 0, loc)},
+				{"Early morning", time.Date(2025, 1, 21, 3, 0, 0, 0, loc)},
+				{"Morning", time.Date(2025, 1, 21, 7, 0, 0, 0, loc)},
+			} {
+				p.now = func() time.Time { return tt.now }
+				if err := p.Post(ctx, arrivalOf(tt.name)); err != nil {
+					t.Fatalf("error posting: %s", err)
+				}
+			}
+
+			if want := []string{"Evening", "Morning"}; !slices.Equal(m.posted, want) {
+				log.Printf("want: %v", want)
+				log.Printf("got : %v", m.posted)
+				t.Errorf("posted arrivals differ")
+			}
+
+			wantPending := 0
+			if mode == QuietDefer {
+				wantPending = 2
+				if end := p.quietEnd(time.Date(2025, 1, 20, 23, 0, 0, 0, loc)); !end.Equal(time.Date(2025, 1, 21, 7, 0, 0, 0, loc)) {
+					t.Errorf("unexpected end of quiet hours %s", end)
+				}
+			}
+			if p.Pending() != wantPending {
+				t.Errorf("unexpected pending arrivals %d, want %d", p.Pending(), wantPending)
+			}
+
+			p.flushDeferred()
+			if mode == QuietSuppress {
+				if len(m.summaries) != 0 {
+					t.Errorf("suppressed arrivals were announced: %+v", m.summaries)
+				}
+				return
+			}
+			if len(m.summaries) != 1 || !m.summaries[0].Deferred {
+				t.Fatalf("unexpected summaries %+v", m.summaries)
+			}
+			if got, want := summaryNames(m.summaries[0]), []string{"Late night", "Early morning"}; !slices.Equal(got, want) {
+				log.Printf("want: %v", want)
+				log.Printf("got : %v", got)
+				t.Errorf("deferred arrivals differ")
+			}
+		})
+	}
+}
+
+func TestPolicyRestore(t *testing.T) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+	ctx := context.Background()
+	conf := PolicyConfig{QuietStart: 22 * time.Hour, QuietEnd: 7 * time.Hour, QuietMode: QuietDefer}
+	night := time.Date(2025, 1, 20, 23, 0, 0, 0, loc)
+	outbox := &mockOutbox{}
+
+	m := &mockSummarizer{}
+	p := NewPolicy(m, conf, loc, outbox)
+	p.now = func() time.Time { return night }
+	for _, name := range []string{"Alice", "Bob"} {
+		if err := p.Post(ctx, arrivalOf(name)); err != nil {
+			t.Fatalf("error posting: %s", err)
+		}
+	}
+	p.Close()
+	if len(m.summaries) != 0 || len(outbox.arrivals) != 2 {
+		t.Fatalf("deferred arrivals should be kept on close, got summaries %+v", m.summaries)
+	}
+
+	// Restarted during quiet hours, they wait for them to end
+	m = &mockSummarizer{}
+	p = NewPolicy(m, conf, loc, outbox)
+	p.now = func() time.Time { return night.Add(time.Hour) }
+	if err := p.Restore(ctx); err != nil {
+		t.Fatalf("error restoring: %s", err)
+	}
+	if p.Pending() != 2 {
+		t.Errorf("unexpected pending arrivals %d", p.Pending())
+	}
+	p.Close()
+
+	// Restarted after quiet hours ended, they're announced right away
+	m = &mockSummarizer{}
+	p = NewPolicy(m, conf, loc, outbox)
+	p.now = func() time.Time { return night.Add(9 * time.Hour) }
+	if err := p.Restore(ctx); err != nil {
+		t.Fatalf("error restoring: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.Close()
+
+	m.Lock()
+	defer m.Unlock()
+	if len(m.summaries) != 1 || !m.summaries[0].Deferred {
+		t.Fatalf("unexpected summaries %+v", m.summaries)
+	}
+	if got, want := summaryNames(m.summaries[0]), []string{"Alice", "Bob"}; !slices.Equal(got, want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %v", got)
+		t.Errorf("restored arrivals differ")
+	}
+	if n, _ := outbox.DeferredArrivals(ctx); len(n) != 0 {
+		t.Errorf("outbox should be empty after announcing, got %d", len(n))
+	}
+}
+
+func TestPolicyBatching(t *testing.T) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+	ctx := context.Background()
+	start := time.Date(2025, 1, 20, 19, 0, 0, 0, loc)
+
+	m := &mockSummarizer{}
+	p := NewPolicy(m, PolicyConfig{BatchWindow: time.Hour}, loc, nil)
+	defer p.Close()
+
+	for i, name := range []string{"Alice", "Bob", "Carol", "Dave"} {
+		p.now = func() time.Time { return start.Add(time.Duration(i) * time.Minute) }
+		if err := p.Post(ctx, arrivalOf(name)); err != nil {
+			t.Fatalf("error posting: %s", err)
+		}
+	}
+
+	// The first arrival is announced right away, the rest when the window
+	// ends
+	if want := []string{"Alice"}; !slices.Equal(m.posted, want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %v", m.posted)
+		t.Errorf("posted arrivals differ")
+	}
+	if p.Pending() != 3 {
+		t.Errorf("unexpected pending arrivals %d", p.Pending())
+	}
+
+	p.now = func() time.Time { return start.Add(time.Hour) }
+	p.flushBatch()
+	if len(m.summaries) != 1 || m.summaries[0].Deferred {
+		t.Fatalf("unexpected summaries %+v", m.summaries)
+	}
+	if got, want := summaryNames(m.summaries[0]), []string{"Bob", "Carol", "Dave"}; !slices.Equal(got, want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %v", got)
+		t.Errorf("batched arrivals differ")
+	}
+
+	// Flushing opened a new window, and a lone arrival in it is posted as is
+	p.now = func() time.Time { return start.Add(90 * time.Minute) }
+	if err := p.Post(ctx, arrivalOf("Eve")); err != nil {
+		t.Fatalf("error posting: %s", err)
+	}
+	p.Close()
+	if want := []string{"Alice", "Eve"}; !slices.Equal(m.posted, want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %v", m.posted)
+		t.Errorf("posted arrivals differ")
+	}
+}
+
+func TestSummaryText(t *testing.T) {
+	data := func(names ...string) []AnnouncementData {
+		var d []AnnouncementData
+		for _, n := range names {
+			d = append(d, NewAnnouncementData(arrivalOf(n)))
+		}
+		return d
+	}
+
+	medalStats := types.Stats{Name: "Medalist", Total: 7, Streak: 1}
+	medalist := NewAnnouncementData(types.Arrival{Stats: medalStats, Achievements: earned(medalStats)})
+	for _, tt := range []struct {
+		name     string
+		data     []AnnouncementData
+		deferred bool
+		want     string
+	}{
+		{"One", data("Alice"), false, "Alice arrived"},
+		{"Two", data("Alice", "Bob"), false, "Alice and Bob arrived"},
+		{"Three", data("Alice", "Bob", "Carol"), false, "Alice, Bob and Carol arrived"},
+		{"Six", data("Alice", "Bob", "Carol", "Dave", "Eve", "Frank"), false, "Alice, Bob and 4 others arrived"},
+		{"Deferred", data("Alice"), true, ":crescent_moon: During quiet hours Alice arrived"},
+		{
+			"Badges",
+			append(data("Alice"), medalist),
+			false,
+			"Alice and Medalist arrived\n• Medalist got the *UNO* medal: :fatcat-yellow:",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summaryText(tt.data, tt.deferred); got != tt.want {
+				log.Printf("want: %s", tt.want)
+				log.Printf("got : %s", got)
+				t.Error("strings differ")
+			}
+		})
+	}
+}
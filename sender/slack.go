@@ -2,138 +2,327 @@ package sender
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"maps"
-	"slices"
+	"log/slog"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/fatcatfablab/doorbot2/metrics"
 	"github.com/fatcatfablab/doorbot2/types"
 	"github.com/slack-go/slack"
 )
 
 const (
-	badgeEarnedFmt = ":tada: Achievement unlocked! You get the %s medal: %s"
-	slackInitMsg   = `Primordial abyss abandoned. Initiating connection to Slack. ` +
+	slackInitMsg = `Primordial abyss abandoned. Initiating connection to Slack. ` +
 		`Resuming sentinel duty. New arrivals shall be announced once more.`
-)
 
-var (
-	totalsConf = map[uint]badge{
-		0:   {badge: ":fatcat:", msg: ""},
-		6:   {badge: ":fatcat-yellow:", msg: "UNO"},
-		30:  {badge: ":fatcat-green:", msg: "TEENSY"},
-		99:  {badge: ":fatcat-blue:", msg: "RPI"},
-		364: {badge: ":fatcat-pink:", msg: "COMMUNITY"},
-		499: {badge: ":fatcat-red:", msg: "CORE"},
-		999: {badge: ":fatcat-black:", msg: "PILLAR"},
-	}
-
-	streaksConf = map[uint]badge{
-		0:   {badge: ":cat2:", msg: ""},
-		4:   {badge: ":black_cat:", msg: "One dedicated cat!"},
-		13:  {badge: ":rat:", msg: "Lab cat to lab rat!"},
-		30:  {badge: ":tiger2:", msg: "What the ...?"},
-		182: {badge: ":leopard:", msg: "Do you sleep here?"},
-		365: {badge: ":house_with_garden:", msg: "You DO live here! Welcome home."},
-	}
+	// How long the result of an auth test is reused, so frequent readiness
+	// probes don't hit Slack every time
+	authCheckTtl = time.Minute
 )
 
-type badge struct {
-	badge string
-	msg   string
+type SlackConfig struct {
+	Channel string
+	Token   string
+	Silent  bool
+	// Send announcements as Block Kit blocks. The rendered template is still
+	// used as the plain text fallback for notifications.
+	Blocks bool
+	// @-mention members mapped to a Slack user instead of using their name
+	Mentions bool
+	// DM members mapped to a Slack user when they earn a badge
+	DirectMessages bool
+	// Post arrivals as replies to a daily thread instead of as channel
+	// messages
+	Threads bool
+}
+
+// SlackStore keeps the Slack state that needs to survive restarts
+type SlackStore interface {
+	// SlackUser maps member names to Slack user ids. An empty id means the
+	// member isn't mapped.
+	SlackUser(ctx context.Context, name string) (string, error)
+	// SlackThread returns the thread of a day, formatted as 2006-01-02, in
+	// a channel, if there's any
+	SlackThread(ctx context.Context, day, channel string) (types.SlackThread, bool, error)
+	SetSlackThread(ctx context.Context, day, channel string, t types.SlackThread) error
+	// AddSlackThreadAttendees records members announced in the thread of a
+	// day, returning how many different ones there are
+	AddSlackThreadAttendees(ctx context.Context, day, channel string, names []string) (uint, error)
 }
 
 type SlackSender struct {
-	client  *slack.Client
-	channel string
-	silent  bool
+	client    *slack.Client
+	channel   string
+	silent    bool
+	blocks    bool
+	mentions  bool
+	dms       bool
+	threads   bool
+	store     SlackStore
+	announcer *Announcer
+
+	// Serializes posting to threads, so concurrent arrivals don't start
+	// more than one thread a day
+	threadMu sync.Mutex
+
+	authMu        sync.Mutex
+	authCheckedAt time.Time
+	authErr       error
 }
 
-func NewSlack(channel, token string, silent bool) *SlackSender {
-	client := slack.New(token)
+// NewSlack returns a sender posting announcements to a Slack channel. store
+// can be nil, in which case members are never mentioned nor DM'ed, and
+// threads are disabled.
+func NewSlack(conf SlackConfig, announcer *Announcer, store SlackStore) *SlackSender {
+	client := slack.New(conf.Token)
 
-	if !silent {
+	if !conf.Silent {
 		c, ts, err := client.PostMessage(
-			channel,
+			conf.Channel,
 			slack.MsgOptionText(slackInitMsg, false),
 		)
 		if err != nil {
-			log.Printf("error posting to slack: %s", err)
+			slog.Error("error posting to slack", "err", err)
 		} else {
-			log.Printf("slack message posted to %s at %s", c, ts)
+			slog.Info("Slack message posted", "channel", c, "ts", ts)
 		}
 	}
-	return &SlackSender{client: client, channel: channel, silent: silent}
+	return &SlackSender{
+		client:    client,
+		channel:   conf.Channel,
+		silent:    conf.Silent,
+		blocks:    conf.Blocks,
+		mentions:  conf.Mentions,
+		dms:       conf.DirectMessages,
+		threads:   conf.Threads && store != nil,
+		store:     store,
+		announcer: announcer,
+	}
 }
 
-func (s *SlackSender) Post(ctx context.Context, stats types.Stats) error {
-	if !s.silent {
-		c, ts, err := s.client.PostMessageContext(
-			ctx,
-			s.channel,
-			slack.MsgOptionText(statsToString(stats), false),
-		)
-		if err != nil {
-			return fmt.Errorf("error posting msg to slack: %w", err)
+func (s *SlackSender) Post(ctx context.Context, a types.Arrival) error {
+	d := NewAnnouncementData(a)
+	slackUser := s.slackUser(ctx, a.Stats.Name)
+	if s.mentions {
+		d = d.WithSlackUser(slackUser)
+	}
+
+	msg, err := s.announcer.RenderData(d)
+	if err != nil {
+		return err
+	}
+
+	opts := []slack.MsgOption{slack.MsgOptionText(msg, false)}
+	if s.blocks {
+		opts = append(opts, slack.MsgOptionBlocks(arrivalBlocks(d)...))
+	}
+	if err := s.post(ctx, arrivalTime(a), []string{a.Stats.Name}, opts...); err != nil {
+		return err
+	}
+
+	if s.dms && slackUser != "" && d.earned() {
+		if err := s.congratulate(ctx, slackUser, d); err != nil {
+			return err
 		}
-		log.Printf("Msg posted to %s (%s) at %s", s.channel, c, ts)
-	} else {
-		log.Printf("(silent mode) Msg NOT posted to %s", s.channel)
 	}
 
 	return nil
 }
 
-func statsToString(stats types.Stats) string {
-	tBadge, tEarned := getTotalBadge(stats.Total)
-	sBadge, sEarned := getStreakBadge(stats.Streak)
-
-	var sb strings.Builder
-	fmt.Fprintf(
-		&sb,
-		"%s %s %d %s %d",
-		stats.Name,
-		tBadge.badge,
-		stats.Total,
-		sBadge.badge,
-		stats.Streak,
-	)
+// PostSummary announces several arrivals in a single message. Members are
+// still DM'ed one by one about their badges.
+func (s *SlackSender) PostSummary(ctx context.Context, sum Summary) error {
+	data := make([]AnnouncementData, len(sum.Arrivals))
+	users := make([]string, len(sum.Arrivals))
+	names := make([]string, len(sum.Arrivals))
+	for i, a := range sum.Arrivals {
+		names[i] = a.Stats.Name
+		data[i] = NewAnnouncementData(a)
+		users[i] = s.slackUser(ctx, a.Stats.Name)
+		if s.mentions {
+			data[i] = data[i].WithSlackUser(users[i])
+		}
+	}
+
+	last := arrivalTime(sum.Arrivals[len(sum.Arrivals)-1])
+	msg := slack.MsgOptionText(summaryText(data, sum.Deferred), false)
+	if err := s.post(ctx, last, names, msg); err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, d := range data {
+		if s.dms && users[i] != "" && d.earned() {
+			if err := s.congratulate(ctx, users[i], d); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PostLeaderboard posts the leaderboard to the channel, outside any daily
+// thread
+func (s *SlackSender) PostLeaderboard(ctx context.Context, l Leaderboard) error {
+	if s.silent {
+		slog.InfoContext(ctx, "(silent mode) Leaderboard NOT posted", "channel", s.channel)
+		return nil
+	}
+
+	c, ts, err := s.postMessage(ctx, s.channel, slack.MsgOptionText(l.Text(), false))
+	if err != nil {
+		return fmt.Errorf("error posting leaderboard to slack: %w", err)
+	}
+	slog.InfoContext(ctx, "Leaderboard posted", "channel", s.channel, "channel_id", c, "ts", ts)
+	return nil
+}
 
-	if tEarned && stats.Total > 1 {
-		fmt.Fprintf(&sb, "\n"+badgeEarnedFmt, tBadge.msg, tBadge.badge)
+// post sends a message announcing attendees arrivals, the last of them at
+// arrived
+func (s *SlackSender) post(ctx context.Context, arrived time.Time, names []string, opts ...slack.MsgOption) error {
+	if s.silent {
+		slog.InfoContext(ctx, "(silent mode) Msg NOT posted", "channel", s.channel)
+		return nil
 	}
+	if s.threads {
+		return s.postToThread(ctx, arrived, names, opts...)
+	}
+
+	c, ts, err := s.postMessage(ctx, s.channel, opts...)
+	if err != nil {
+		return fmt.Errorf("error posting msg to slack: %w", err)
+	}
+	slog.InfoContext(ctx, "Msg posted", "channel", s.channel, "channel_id", c, "ts", ts)
+	return nil
+}
 
-	if sEarned && stats.Streak > 1 {
-		fmt.Fprintf(&sb, "\n%s", sBadge.msg)
+// CheckAuth tests the Slack token, reusing the last result for a while. In
+// silent mode nothing is posted, so there's nothing to check.
+func (s *SlackSender) CheckAuth(ctx context.Context) error {
+	if s.silent {
+		return nil
 	}
 
-	return sb.String()
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	if time.Since(s.authCheckedAt) < authCheckTtl {
+		return s.authErr
+	}
+
+	_, err := s.client.AuthTestContext(ctx)
+	if err != nil {
+		err = fmt.Errorf("error testing slack auth: %w", err)
+	}
+	// Results of cancelled requests say nothing about the token
+	if ctx.Err() == nil {
+		s.authCheckedAt, s.authErr = time.Now(), err
+	}
+	return err
 }
 
-func getTotalBadge(total uint) (badge, bool) {
-	return findBadge(total, totalsConf)
+// postMessage posts to a channel or user, counting how it went in the
+// metrics
+func (s *SlackSender) postMessage(ctx context.Context, channel string, opts ...slack.MsgOption) (string, string, error) {
+	c, ts, err := s.client.PostMessageContext(ctx, channel, opts...)
+	if err != nil {
+		metrics.SlackPosts.Inc("error")
+	} else {
+		metrics.SlackPosts.Inc("ok")
+	}
+	return c, ts, err
 }
 
-func getStreakBadge(streak uint) (badge, bool) {
-	return findBadge(streak, streaksConf)
+// slackUser looks up the Slack user of a member, if mentions or DMs are
+// enabled. Lookup errors are logged and the member treated as unmapped so
+// the announcement still goes out.
+func (s *SlackSender) slackUser(ctx context.Context, name string) string {
+	if s.store == nil || !(s.mentions || s.dms) {
+		return ""
+	}
+	id, err := s.store.SlackUser(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "error looking up slack user", "member", name, "err", err)
+		return ""
+	}
+	return id
 }
 
-func findBadge(num uint, conf map[uint]badge) (badge, bool) {
-	var b badge
-	var earned bool
-
-	thresholds := slices.Collect(maps.Keys(conf))
-	slices.Sort(thresholds)
-	slices.Reverse(thresholds)
-	for _, t := range thresholds {
-		if num > t {
-			b = conf[uint(t)]
-			if num == t+1 {
-				earned = true
-			}
-			break
+// congratulate DMs a member about the badges earned on this visit
+func (s *SlackSender) congratulate(ctx context.Context, slackUser string, d AnnouncementData) error {
+	var lines []string
+	if d.TotalEarned {
+		lines = append(lines, fmt.Sprintf(
+			":tada: Congrats! %d visits got you the *%s* medal: %s",
+			d.Total,
+			d.TotalBadge.Msg,
+			d.TotalBadge.Emoji,
+		))
+	}
+	if d.StreakEarned {
+		lines = append(lines, fmt.Sprintf(
+			":fire: %d days in a row! %s %s",
+			d.Streak,
+			d.StreakBadge.Msg,
+			d.StreakBadge.Emoji,
+		))
+	}
+
+	for _, a := range d.Achievements {
+		lines = append(lines, fmt.Sprintf(":medal: %s %s", a.Msg, a.Emoji))
+	}
+
+	if s.silent {
+		slog.InfoContext(ctx, "(silent mode) DM NOT sent", "user", slackUser)
+		return nil
+	}
+
+	_, _, err := s.postMessage(
+		ctx,
+		slackUser,
+		slack.MsgOptionText(strings.Join(lines, "\n"), false),
+	)
+	if err != nil {
+		return fmt.Errorf("error sending DM to %s: %w", slackUser, err)
+	}
+	slog.InfoContext(ctx, "DM sent", "user", slackUser)
+	return nil
+}
+
+// summaryText lists who arrived, like "Alice, Bob and 4 others arrived",
+// followed by the badges they earned
+func summaryText(data []AnnouncementData, deferred bool) string {
+	names := make([]string, len(data))
+	for i, d := range data {
+		names[i] = d.Mention
+	}
+
+	var who string
+	switch n := len(names); {
+	case n == 1:
+		who = names[0]
+	case n <= 3:
+		who = strings.Join(names[:n-1], ", ") + " and " + names[n-1]
+	default:
+		who = fmt.Sprintf("%s and %d others", strings.Join(names[:2], ", "), n-2)
+	}
+
+	lines := []string{who + " arrived"}
+	if deferred {
+		lines[0] = ":crescent_moon: During quiet hours " + lines[0]
+	}
+	for _, d := range data {
+		if d.TotalEarned {
+			lines = append(lines, fmt.Sprintf("• %s got the *%s* medal: %s", d.Mention, d.TotalBadge.Msg, d.TotalBadge.Emoji))
+		}
+		if d.StreakEarned {
+			lines = append(lines, fmt.Sprintf("• %s: %s %s", d.Mention, d.StreakBadge.Msg, d.StreakBadge.Emoji))
+		}
+		for _, a := range d.Achievements {
+			lines = append(lines, fmt.Sprintf("• %s: %s %s", d.Mention, a.Msg, a.Emoji))
 		}
 	}
-	return b, earned
+	return strings.Join(lines, "\n")
 }
@@ -1,22 +1,39 @@
 package sender
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/fatcatfablab/doorbot2/types"
+	"github.com/slack-go/slack"
 )
 
 const (
 	name = "Johnny Melavo"
 )
 
-func TestStatsToString(t *testing.T) {
+func TestDefaultAnnouncement(t *testing.T) {
+	announcer, err := NewAnnouncer("")
+	if err != nil {
+		t.Fatalf("error loading default template: %s", err)
+	}
+
 	for _, tt := range []struct {
-		name  string
-		stats types.Stats
-		want  string
+		name         string
+		stats        types.Stats
+		daysAway     uint
+		brokenStreak uint
+		achievements []types.Achievement
+		want         string
 	}{
 		{
 			name:  "First visit",
@@ -52,9 +69,57 @@ func TestStatsToString(t *testing.T) {
 				name, ":fatcat-green:", 31, ":rat:", 14,
 			),
 		},
+		{
+			name:     "Short absence",
+			stats:    types.Stats{Name: name, Total: 20, Streak: 1},
+			daysAway: 29,
+			want:     fmt.Sprintf("%s %s %d %s %d", name, ":fatcat-yellow:", 20, ":cat2:", 1),
+		},
+		{
+			name:         "Comeback after a long streak",
+			stats:        types.Stats{Name: name, Total: 20, Streak: 1},
+			daysAway:     90,
+			brokenStreak: 12,
+			want: fmt.Sprintf(
+				"%s %s %d %s %d"+
+					"\n:wave: Welcome back after 90 days!"+
+					"\nNew streak started (previous: 12)",
+				name, ":fatcat-yellow:", 20, ":cat2:", 1,
+			),
+		},
+		{
+			name:  "Achievements",
+			stats: types.Stats{Name: name, Total: 7, Streak: 1},
+			achievements: []types.Achievement{
+				{Id: "early-bird", Emoji: ":hatching_chick:", Msg: "Early bird: in before 7am"},
+				{Id: "anniversary-1", Emoji: ":birthday:", Msg: "One year at the lab!"},
+			},
+			want: fmt.Sprintf(
+				"%s %s %d %s %d"+
+					"\n:tada: Achievement unlocked! You get the UNO medal: :fatcat-yellow:"+
+					"\n:hatching_chick: Early bird: in before 7am"+
+					"\n:birthday: One year at the lab!",
+				name, ":fatcat-yellow:", 7, ":cat2:", 1,
+			),
+		},
+		{
+			name:         "Short streak broken",
+			stats:        types.Stats{Name: name, Total: 20, Streak: 1},
+			daysAway:     2,
+			brokenStreak: 4,
+			want:         fmt.Sprintf("%s %s %d %s %d", name, ":fatcat-yellow:", 20, ":cat2:", 1),
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			got := statsToString(tt.stats)
+			got, err := announcer.Render(types.Arrival{
+				Stats:        tt.stats,
+				DaysAway:     tt.daysAway,
+				BrokenStreak: tt.brokenStreak,
+				Achievements: earned(tt.stats, tt.achievements...),
+			})
+			if err != nil {
+				t.Fatalf("error rendering: %s", err)
+			}
 			if got != tt.want {
 				log.Printf("want: %s", tt.want)
 				log.Printf("got : %s", got)
@@ -64,3 +129,261 @@ func TestStatsToString(t *testing.T) {
 	}
 
 }
+
+type mockSlackStore struct {
+	users     map[string]string
+	threads   map[string]types.SlackThread
+	attendees map[string]map[string]bool
+}
+
+func (m *mockSlackStore) SlackUser(_ context.Context, name string) (string, error) {
+	return m.users[name], nil
+}
+
+func (m *mockSlackStore) SlackThread(_ context.Context, day, channel string) (types.SlackThread, bool, error) {
+	t, ok := m.threads[day+channel]
+	return t, ok, nil
+}
+
+func (m *mockSlackStore) SetSlackThread(_ context.Context, day, channel string, t types.SlackThread) error {
+	if m.threads == nil {
+		m.threads = make(map[string]types.SlackThread)
+	}
+	m.threads[day+channel] = t
+	return nil
+}
+
+func (m *mockSlackStore) AddSlackThreadAttendees(_ context.Context, day, channel string, names []string) (uint, error) {
+	if m.attendees == nil {
+		m.attendees = make(map[string]map[string]bool)
+	}
+	if m.attendees[day+channel] == nil {
+		m.attendees[day+channel] = make(map[string]bool)
+	}
+	for _, name := range names {
+		m.attendees[day+channel][name] = true
+	}
+	return uint(len(m.attendees[day+channel])), nil
+}
+
+type slackMsg struct {
+	channel string
+	text    string
+}
+
+// fakeSlack records every chat.postMessage and chat.update call. Posted
+// messages get increasing timestamps. With failReplies, thread replies fail
+// and aren't recorded.
+type fakeSlack struct {
+	sync.Mutex
+	msgs        []slackMsg
+	threadTs    []string
+	updates     []slackMsg
+	failReplies bool
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	msg := slackMsg{channel: r.FormValue("channel"), text: r.FormValue("text")}
+	if strings.HasSuffix(r.URL.Path, "/chat.update") {
+		f.updates = append(f.updates, msg)
+		fmt.Fprintf(w, `{"ok": true, "channel": %q, "ts": %q}`, msg.channel, r.FormValue("ts"))
+		return
+	}
+	if f.failReplies && r.FormValue("thread_ts") != "" {
+		fmt.Fprint(w, `{"ok": false, "error": "fatal_error"}`)
+		return
+	}
+
+	f.msgs = append(f.msgs, msg)
+	f.threadTs = append(f.threadTs, r.FormValue("thread_ts"))
+	fmt.Fprintf(w, `{"ok": true, "channel": %q, "ts": "%d"}`, msg.channel, len(f.msgs))
+}
+
+func TestSlackMentionsAndDMs(t *testing.T) {
+	announcer, err := NewAnnouncer("")
+	if err != nil {
+		t.Fatalf("error loading default template: %s", err)
+	}
+	store := &mockSlackStore{users: map[string]string{name: "U123"}}
+
+	for _, tt := range []struct {
+		name  string
+		conf  SlackConfig
+		stats types.Stats
+		want  []slackMsg
+	}{
+		{
+			name:  "mention",
+			conf:  SlackConfig{Channel: "C1", Mentions: true, DirectMessages: true},
+			stats: types.Stats{Name: name, Total: 2, Streak: 1},
+			want:  []slackMsg{{"C1", "<@U123> :fatcat: 2 :cat2: 1"}},
+		},
+		{
+			name:  "unmapped member",
+			conf:  SlackConfig{Channel: "C1", Mentions: true, DirectMessages: true},
+			stats: types.Stats{Name: "Somebody", Total: 7, Streak: 1},
+			want: []slackMsg{{
+				"C1",
+				"Somebody :fatcat-yellow: 7 :cat2: 1\n:tada: Achievement unlocked! You get the UNO medal: :fatcat-yellow:",
+			}},
+		},
+		{
+			name:  "badge DM without mentions",
+			conf:  SlackConfig{Channel: "C1", DirectMessages: true},
+			stats: types.Stats{Name: name, Total: 7, Streak: 5},
+			want: []slackMsg{
+				{
+					"C1",
+					name + " :fatcat-yellow: 7 :black_cat: 5" +
+						"\n:tada: Achievement unlocked! You get the UNO medal: :fatcat-yellow:" +
+						"\nOne dedicated cat!",
+				},
+				{
+					"U123",
+					":tada: Congrats! 7 visits got you the *UNO* medal: :fatcat-yellow:" +
+						"\n:fire: 5 days in a row! One dedicated cat! :black_cat:",
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSlack{}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			s := NewSlack(SlackConfig{Silent: true}, announcer, store)
+			s.client = slack.New("token", slack.OptionAPIURL(srv.URL+"/"))
+			s.channel = tt.conf.Channel
+			s.silent = false
+			s.mentions = tt.conf.Mentions
+			s.dms = tt.conf.DirectMessages
+
+			if err := s.Post(context.Background(), types.Arrival{Stats: tt.stats, Achievements: earned(tt.stats)}); err != nil {
+				t.Fatalf("error posting: %s", err)
+			}
+			if !slices.Equal(fake.msgs, tt.want) {
+				log.Printf("want: %q", tt.want)
+				log.Printf("got : %q", fake.msgs)
+				t.Error("messages differ")
+			}
+		})
+	}
+}
+
+func TestSlackThreads(t *testing.T) {
+	announcer, err := NewAnnouncer("")
+	if err != nil {
+		t.Fatalf("error loading default template: %s", err)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		t.Fatalf("error loading timezone: %s", err)
+	}
+
+	fake := &fakeSlack{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	store := &mockSlackStore{}
+	newSender := func() *SlackSender {
+		s := NewSlack(SlackConfig{Silent: true, Threads: true}, announcer, store)
+		s.client = slack.New("token", slack.OptionAPIURL(srv.URL+"/"))
+		s.channel = "#general"
+		s.silent = false
+		return s
+	}
+
+	ctx := context.Background()
+	day := time.Date(2025, 1, 20, 18, 0, 0, 0, loc)
+	arrival := func(name string, ts time.Time) types.Arrival {
+		return types.Arrival{
+			Record: types.AccessRecord{Timestamp: ts, Name: name, AccessGranted: true},
+			Stats:  types.Stats{Name: name, Total: 2, Streak: 1, Last: ts},
+		}
+	}
+
+	s := newSender()
+	if err := s.Post(ctx, arrival("Alice", day)); err != nil {
+		t.Fatalf("error posting: %s", err)
+	}
+	if err := s.PostSummary(ctx, Summary{Arrivals: []types.Arrival{
+		arrival("Bob", day.Add(time.Minute)),
+		arrival("Carol", day.Add(2*time.Minute)),
+	}}); err != nil {
+		t.Fatalf("error posting summary: %s", err)
+	}
+	// The thread survives a restart
+	s = newSender()
+	if err := s.Post(ctx, arrival("Dave", day.Add(time.Hour))); err != nil {
+		t.Fatalf("error posting: %s", err)
+	}
+	// Members announced again don't count twice
+	if err := s.Post(ctx, arrival("Alice", day.Add(2*time.Hour))); err != nil {
+		t.Fatalf("error posting: %s", err)
+	}
+	// A new day gets a new thread, kept even if the first reply fails
+	fake.failReplies = true
+	if err := s.Post(ctx, arrival("Eve", day.AddDate(0, 0, 1))); err == nil {
+		t.Fatalf("expected an error posting the reply")
+	}
+	fake.failReplies = false
+	if err := s.Post(ctx, arrival("Frank", day.AddDate(0, 0, 1).Add(time.Minute))); err != nil {
+		t.Fatalf("error posting: %s", err)
+	}
+
+	wantMsgs := []slackMsg{
+		{"#general", ":house: *Today at the lab*, Monday, January 20\n0 members so far"},
+		{"#general", "Alice :fatcat: 2 :cat2: 1"},
+		{"#general", "Bob and Carol arrived"},
+		{"#general", "Dave :fatcat: 2 :cat2: 1"},
+		{"#general", "Alice :fatcat: 2 :cat2: 1"},
+		{"#general", ":house: *Today at the lab*, Tuesday, January 21\n0 members so far"},
+		{"#general", "Frank :fatcat: 2 :cat2: 1"},
+	}
+	wantThreadTs := []string{"", "1", "1", "1", "1", "", "6"}
+	wantUpdates := []slackMsg{
+		{"#general", ":house: *Today at the lab*, Monday, January 20\n1 member so far"},
+		{"#general", ":house: *Today at the lab*, Monday, January 20\n3 members so far"},
+		{"#general", ":house: *Today at the lab*, Monday, January 20\n4 members so far"},
+		{"#general", ":house: *Today at the lab*, Monday, January 20\n4 members so far"},
+		{"#general", ":house: *Today at the lab*, Tuesday, January 21\n1 member so far"},
+	}
+	if !slices.Equal(fake.msgs, wantMsgs) || !slices.Equal(fake.threadTs, wantThreadTs) {
+		log.Printf("want: %q %q", wantMsgs, wantThreadTs)
+		log.Printf("got : %q %q", fake.msgs, fake.threadTs)
+		t.Error("messages differ")
+	}
+	if !slices.Equal(fake.updates, wantUpdates) {
+		log.Printf("want: %q", wantUpdates)
+		log.Printf("got : %q", fake.updates)
+		t.Error("updates differ")
+	}
+}
+
+func TestSlackCheckAuth(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprint(w, `{"ok": false, "error": "invalid_auth"}`)
+	}))
+	defer srv.Close()
+
+	s := NewSlack(SlackConfig{Silent: true}, nil, nil)
+	s.client = slack.New("token", slack.OptionAPIURL(srv.URL+"/"))
+	if err := s.CheckAuth(context.Background()); err != nil {
+		t.Errorf("unexpected error in silent mode: %s", err)
+	}
+
+	s.silent = false
+	for i := 0; i < 2; i++ {
+		if err := s.CheckAuth(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("unexpected auth tests %d, want the result reused", calls.Load())
+	}
+}
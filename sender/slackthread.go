@@ -0,0 +1,92 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+	"github.com/slack-go/slack"
+)
+
+// postToThread posts a message announcing names as a reply to the thread of
+// the day arrived is in, starting the thread if needed, and updates the
+// attendee count in the parent message
+func (s *SlackSender) postToThread(ctx context.Context, arrived time.Time, names []string, opts ...slack.MsgOption) error {
+	s.threadMu.Lock()
+	defer s.threadMu.Unlock()
+
+	day := arrived.Format(time.DateOnly)
+	thread, ok, err := s.store.SlackThread(ctx, day, s.channel)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		thread.ChannelId, thread.Ts, err = s.postMessage(
+			ctx,
+			s.channel,
+			slack.MsgOptionText(threadText(arrived, 0), false),
+		)
+		if err != nil {
+			return fmt.Errorf("error starting slack thread: %w", err)
+		}
+		slog.InfoContext(ctx, "Slack thread started", "day", day, "channel", thread.ChannelId, "ts", thread.Ts)
+
+		// Stored right away, so the thread is reused even if the reply fails
+		if err := s.store.SetSlackThread(ctx, day, s.channel, thread); err != nil {
+			return err
+		}
+	}
+
+	opts = append(opts, slack.MsgOptionTS(thread.Ts))
+	_, ts, err := s.postMessage(ctx, thread.ChannelId, opts...)
+	if err != nil {
+		return fmt.Errorf("error posting msg to slack thread: %w", err)
+	}
+	slog.InfoContext(ctx, "Msg posted to slack thread", "thread", thread.Ts, "channel", thread.ChannelId, "ts", ts)
+
+	// Members can be announced more than once a day, like when earning an
+	// achievement on a later visit
+	attendees, err := s.store.AddSlackThreadAttendees(ctx, day, s.channel, names)
+	if err != nil {
+		return err
+	}
+
+	_, _, _, err = s.client.UpdateMessageContext(
+		ctx,
+		thread.ChannelId,
+		thread.Ts,
+		slack.MsgOptionText(threadText(arrived, attendees), false),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating slack thread: %w", err)
+	}
+	return nil
+}
+
+func threadText(day time.Time, attendees uint) string {
+	members := "members"
+	if attendees == 1 {
+		members = "member"
+	}
+	return fmt.Sprintf(
+		":house: *Today at the lab*, %s\n%d %s so far",
+		day.Format("Monday, January 2"),
+		attendees,
+		members,
+	)
+}
+
+// arrivalTime returns when a member arrived, falling back to the time of
+// their last visit and to now
+func arrivalTime(a types.Arrival) time.Time {
+	switch {
+	case !a.Record.Timestamp.IsZero():
+		return a.Record.Timestamp
+	case !a.Stats.Last.IsZero():
+		return a.Stats.Last
+	default:
+		return time.Now()
+	}
+}
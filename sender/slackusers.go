@@ -0,0 +1,49 @@
+package sender
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/slack-go/slack"
+)
+
+// MatchSlackUsers pairs member names with Slack users whose real name,
+// display name or email address (before the @) is the same once case,
+// spaces and punctuation are ignored. Members matching none or more than one
+// Slack user are left out, as are bots and deactivated accounts.
+func MatchSlackUsers(members []string, users []slack.User) map[string]string {
+	candidates := make(map[string][]string)
+	for _, u := range users {
+		if u.Deleted || u.IsBot {
+			continue
+		}
+
+		local, _, _ := strings.Cut(u.Profile.Email, "@")
+		keys := make(map[string]bool)
+		for _, n := range []string{u.RealName, u.Profile.RealName, u.Profile.DisplayName, local} {
+			if k := matchKey(n); k != "" {
+				keys[k] = true
+			}
+		}
+		for k := range keys {
+			candidates[k] = append(candidates[k], u.ID)
+		}
+	}
+
+	result := make(map[string]string)
+	for _, m := range members {
+		if ids := candidates[matchKey(m)]; len(ids) == 1 {
+			result[m] = ids[0]
+		}
+	}
+	return result
+}
+
+func matchKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,37 @@
+package sender
+
+import (
+	"log"
+	"maps"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestMatchSlackUsers(t *testing.T) {
+	users := []slack.User{
+		{ID: "U1", RealName: "Johnny Melavo"},
+		{ID: "U2", Profile: slack.UserProfile{DisplayName: "jane_doe"}},
+		{ID: "U3", Profile: slack.UserProfile{Email: "bob.smith@example.com"}},
+		{ID: "U4", RealName: "Twin"},
+		{ID: "U5", RealName: "twin"},
+		{ID: "U6", RealName: "Gone", Deleted: true},
+		{ID: "U7", RealName: "Doorbot", IsBot: true},
+		// Same name in several fields of a single user isn't ambiguous
+		{ID: "U8", RealName: "Ann Lee", Profile: slack.UserProfile{RealName: "Ann Lee", DisplayName: "annlee"}},
+	}
+	members := []string{"johnny melavo", "Jane Doe", "Bob Smith", "Twin", "Gone", "Doorbot", "Ann Lee", "Nobody"}
+
+	want := map[string]string{
+		"johnny melavo": "U1",
+		"Jane Doe":      "U2",
+		"Bob Smith":     "U3",
+		"Ann Lee":       "U8",
+	}
+	got := MatchSlackUsers(members, users)
+	if !maps.Equal(got, want) {
+		log.Printf("want: %v", want)
+		log.Printf("got : %v", got)
+		t.Error("matches differ")
+	}
+}
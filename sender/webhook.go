@@ -0,0 +1,222 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	// WebhookSchemaVersion is bumped every time WebhookPayload changes in a
+	// way that isn't backwards compatible
+	WebhookSchemaVersion = 1
+
+	WebhookSignatureHeader = "X-Doorbot2-Signature-256"
+	WebhookVersionHeader   = "X-Doorbot2-Schema-Version"
+	WebhookEventHeader     = "X-Doorbot2-Event"
+
+	webhookArrivalEvent   = "arrival"
+	webhookTimeout        = 10 * time.Second
+	webhookDefaultBackoff = time.Second
+	// Arrivals waiting to be delivered. Arrivals coming in while it's full
+	// are dropped.
+	webhookQueueSize = 100
+)
+
+// WebhookPayload is the JSON body POSTed to every configured URL. See the
+// README for the documented schema.
+type WebhookPayload struct {
+	SchemaVersion int                 `json:"schema_version"`
+	Event         string              `json:"event"`
+	SentAt        time.Time           `json:"sent_at"`
+	Record        types.AccessRecord  `json:"record"`
+	Stats         types.Stats         `json:"stats"`
+	Door          string              `json:"door,omitempty"`
+	DaysAway      uint                `json:"days_away,omitempty"`
+	BrokenStreak  uint                `json:"broken_streak,omitempty"`
+	Achievements  []types.Achievement `json:"achievements,omitempty"`
+}
+
+type WebhookSender struct {
+	client  *http.Client
+	urls    []string
+	secret  []byte
+	headers http.Header
+	retries int
+	backoff time.Duration
+
+	// Guards closing queue, so arrivals coming in while closing are dropped
+	mu     sync.Mutex
+	closed bool
+	queue  chan webhookDelivery
+	done   chan struct{}
+}
+
+type webhookDelivery struct {
+	ctx  context.Context
+	body []byte
+}
+
+// NewWebhook returns a sender that POSTs every arrival to all of the given
+// urls. When secret is not empty, the body is signed with HMAC-SHA256 and the
+// signature sent in the WebhookSignatureHeader. Deliveries failing because of
+// network errors or 5xx/429 responses are retried up to retries times.
+//
+// Deliveries happen in the background, so a slow or dead URL doesn't hold
+// back the caller. Close waits for the queued ones.
+func NewWebhook(urls []string, secret string, headers http.Header, retries int) *WebhookSender {
+	s := &WebhookSender{
+		client:  &http.Client{Timeout: webhookTimeout},
+		urls:    urls,
+		secret:  []byte(secret),
+		headers: headers,
+		retries: retries,
+		backoff: webhookDefaultBackoff,
+		queue:   make(chan webhookDelivery, webhookQueueSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *WebhookSender) Post(ctx context.Context, a types.Arrival) error {
+	body, err := json.Marshal(WebhookPayload{
+		SchemaVersion: WebhookSchemaVersion,
+		Event:         webhookArrivalEvent,
+		SentAt:        time.Now(),
+		Record:        a.Record,
+		Stats:         a.Stats,
+		Door:          a.Door,
+		DaysAway:      a.DaysAway,
+		BrokenStreak:  a.BrokenStreak,
+		Achievements:  a.Achievements,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("webhook sender is closed, dropping arrival")
+	}
+
+	// Deliveries outlive the request that triggered them, but keep its
+	// values for logging
+	select {
+	case s.queue <- webhookDelivery{ctx: context.WithoutCancel(ctx), body: body}:
+		return nil
+	default:
+		return errors.New("webhook queue is full, dropping arrival")
+	}
+}
+
+// Close stops taking arrivals and waits for the queued ones to be delivered
+func (s *WebhookSender) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+func (s *WebhookSender) run() {
+	defer close(s.done)
+	for d := range s.queue {
+		if err := s.deliverAll(d.ctx, d.body); err != nil {
+			slog.ErrorContext(d.ctx, "error delivering webhooks", "err", err)
+		}
+	}
+}
+
+func (s *WebhookSender) deliverAll(ctx context.Context, body []byte) error {
+	var errs []error
+	for _, url := range s.urls {
+		if err := s.deliver(ctx, url, body); err != nil {
+			errs = append(errs, fmt.Errorf("error posting to webhook %q: %w", url, err))
+		} else {
+			slog.InfoContext(ctx, "Webhook delivered", "url", url)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *WebhookSender) deliver(ctx context.Context, url string, body []byte) error {
+	var err error
+	for attempt := range s.retries + 1 {
+		if attempt > 0 {
+			wait := s.backoff * (1 << (attempt - 1))
+			slog.WarnContext(ctx, "Retrying webhook", "url", url, "wait", wait, "err", err)
+			select {
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			case <-time.After(wait):
+			}
+		}
+
+		var retry bool
+		retry, err = s.send(ctx, url, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// send makes a single delivery attempt. The returned bool reports whether a
+// failed attempt is worth retrying.
+func (s *WebhookSender) send(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("error building request: %w", err)
+	}
+
+	for k, v := range s.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookVersionHeader, fmt.Sprint(WebhookSchemaVersion))
+	req.Header.Set(WebhookEventHeader, webhookArrivalEvent)
+	if len(s.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+Sign(s.secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("unexpected status %q", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %q", resp.Status)
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body using secret as the key.
+// Receivers can use it to validate the WebhookSignatureHeader.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
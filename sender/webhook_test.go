@@ -0,0 +1,157 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fatcatfablab/doorbot2/types"
+)
+
+const (
+	secret = "s3cr3t"
+)
+
+func testArrival() types.Arrival {
+	ts := time.Date(2025, 1, 20, 18, 0, 0, 0, time.UTC)
+	return types.Arrival{
+		Record: types.AccessRecord{Timestamp: ts, Name: name, AccessGranted: true},
+		Stats:  types.Stats{Name: name, Total: 7, Streak: 2, Last: ts},
+	}
+}
+
+func TestWebhookPost(t *testing.T) {
+	var got WebhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("error reading body: %s", err)
+		}
+
+		if sig := r.Header.Get(WebhookSignatureHeader); sig != "sha256="+Sign([]byte(secret), body) {
+			t.Errorf("wrong signature %q", sig)
+		}
+		if v := r.Header.Get(WebhookVersionHeader); v != "1" {
+			t.Errorf("wrong schema version header %q", v)
+		}
+		if v := r.Header.Get("X-Custom"); v != "custom" {
+			t.Errorf("missing custom header, got %q", v)
+		}
+
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatalf("error decoding payload: %s", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	headers := http.Header{"X-Custom": {"custom"}}
+	s := NewWebhook([]string{srv.URL}, secret, headers, 0)
+	a := testArrival()
+	if err := s.Post(context.Background(), a); err != nil {
+		t.Fatalf("unexpected error posting: %s", err)
+	}
+	s.Close()
+
+	if got.SchemaVersion != WebhookSchemaVersion || got.Event != webhookArrivalEvent {
+		t.Errorf("unexpected payload header fields: %+v", got)
+	}
+	if !got.Record.Timestamp.Equal(a.Record.Timestamp) || got.Record.Name != a.Record.Name {
+		t.Errorf("records differ: %+v", got.Record)
+	}
+	if got.Stats.Total != a.Stats.Total || got.Stats.Streak != a.Stats.Streak {
+		t.Errorf("stats differ: %+v", got.Stats)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		statuses  []int
+		retries   int
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "Succeeds after server errors",
+			statuses:  []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK},
+			retries:   2,
+			wantCalls: 3,
+		},
+		{
+			name:      "Gives up after retries",
+			statuses:  []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			retries:   1,
+			wantCalls: 2,
+			wantErr:   true,
+		},
+		{
+			name:      "Client errors are not retried",
+			statuses:  []int{http.StatusBadRequest, http.StatusOK},
+			retries:   3,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[calls])
+				calls++
+			}))
+			defer srv.Close()
+
+			s := NewWebhook([]string{srv.URL}, "", nil, tt.retries)
+			s.backoff = time.Millisecond
+			err := s.deliverAll(context.Background(), []byte("{}"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error value: %v", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("unexpected number of calls: %d. Wanted %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestWebhookPostDoesNotWait(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	s := NewWebhook([]string{srv.URL}, "", nil, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	if err := s.Post(ctx, testArrival()); err != nil {
+		t.Fatalf("unexpected error posting: %s", err)
+	}
+	// Like the UDM request finishing before the delivery
+	cancel()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Post waited for the delivery for %s", elapsed)
+	}
+
+	for range webhookQueueSize - 1 {
+		s.Post(ctx, testArrival())
+	}
+	// The first one may have left the queue for delivery already
+	s.Post(ctx, testArrival())
+	if err := s.Post(ctx, testArrival()); err == nil {
+		t.Errorf("expected an error with the queue full")
+	}
+
+	close(release)
+	s.Close()
+
+	// Arrivals coming in while shutting down are dropped
+	if err := s.Post(ctx, testArrival()); err == nil {
+		t.Errorf("expected an error after closing")
+	}
+	s.Close()
+}
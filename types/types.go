@@ -2,11 +2,12 @@ package types
 
 import (
 	"context"
+	"fmt"
 	"time"
 )
 
 type Sender interface {
-	Post(ctx context.Context, s Stats) error
+	Post(ctx context.Context, a Arrival) error
 }
 
 type Stats struct {
@@ -21,3 +22,91 @@ type AccessRecord struct {
 	Name          string    `json:"name"`
 	AccessGranted bool      `json:"access_granted"`
 }
+
+// Arrival is what gets handed to a Sender: the access record that triggered
+// the announcement and the member stats after storing it
+type Arrival struct {
+	Record AccessRecord `json:"record"`
+	Stats  Stats        `json:"stats"`
+	// Name of the door the member came in through, if known
+	Door string `json:"door,omitempty"`
+	// Days since the previous visit. Zero on a first visit.
+	DaysAway uint `json:"days_away,omitempty"`
+	// Streak broken by this arrival, if any
+	BrokenStreak uint `json:"broken_streak,omitempty"`
+	// Achievements awarded for this arrival
+	Achievements []Achievement `json:"achievements,omitempty"`
+}
+
+// Achievement is awarded once per member, the first time they meet a rule
+type Achievement struct {
+	Id        string    `json:"id"`
+	Emoji     string    `json:"emoji"`
+	Msg       string    `json:"msg"`
+	AwardedAt time.Time `json:"awarded_at"`
+}
+
+// Award is an achievement along with the member it was awarded to
+type Award struct {
+	Name string `json:"name"`
+	Achievement
+}
+
+// SlackThread is the thread arrivals of a day are posted to
+type SlackThread struct {
+	ChannelId string
+	Ts        string
+}
+
+// Announce is a member preference on which of their arrivals get announced.
+// History and stats are recorded regardless.
+type Announce string
+
+const (
+	AnnouncePublic       Announce = "public"
+	AnnounceAchievements Announce = "achievements"
+	AnnounceNever        Announce = "never"
+)
+
+func ParseAnnounce(s string) (Announce, error) {
+	switch a := Announce(s); a {
+	case AnnouncePublic, AnnounceAchievements, AnnounceNever:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown announce preference %q", s)
+	}
+}
+
+// Scope is what an API token grants access to
+type Scope string
+
+const (
+	// ScopeRead grants access to the read-only API
+	ScopeRead Scope = "read"
+	// ScopeAdmin grants access to the admin API, and the read-only one too
+	ScopeAdmin Scope = "admin"
+)
+
+func ParseScope(s string) (Scope, error) {
+	switch sc := Scope(s); sc {
+	case ScopeRead, ScopeAdmin:
+		return sc, nil
+	default:
+		return "", fmt.Errorf("unknown scope %q", s)
+	}
+}
+
+// Allows reports whether a token with scope s can be used where want is
+// required
+func (s Scope) Allows(want Scope) bool {
+	return s == want || s == ScopeAdmin
+}
+
+// Token is an API token. Only a hash of the secret is stored, so it can't be
+// recovered after creation.
+type Token struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scope     Scope     `json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+}